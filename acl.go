@@ -0,0 +1,433 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package noisysockets
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/noisysockets/noisysockets/config"
+	"github.com/noisysockets/noisysockets/config/v1alpha1"
+	"github.com/noisysockets/noisysockets/types"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+const (
+	// udpFlowTimeout is how long replies to an outbound UDP flow are allowed
+	// after the last packet was sent (the same as the Linux conntrack default).
+	udpFlowTimeout = 2 * time.Minute
+	// deniedLogInterval is how often denied connections are logged, per peer
+	// and port, so that a misbehaving peer can't flood the logs.
+	deniedLogInterval = time.Minute
+)
+
+// aclPolicy is a parsed access control policy.
+type aclPolicy struct {
+	defaultAllow bool
+	rules        []aclRule
+}
+
+type aclRule struct {
+	allow bool
+	// peerKeys and peerNames are nil if the rule applies to all peers.
+	peerKeys  map[types.NoisePublicKey]struct{}
+	peerNames map[string]struct{}
+	// protocol is empty if the rule applies to all protocols.
+	protocol string
+	// ports is nil if the rule applies to all ports.
+	ports []portRange
+}
+
+type portRange struct {
+	start, end uint16
+}
+
+func parseACL(conf *v1alpha1.ACLConfig) (*aclPolicy, error) {
+	if conf == nil {
+		return nil, nil
+	}
+
+	policy := &aclPolicy{
+		defaultAllow: conf.DefaultAction != v1alpha1.ACLActionDeny,
+	}
+
+	for _, ruleConf := range conf.Rules {
+		rule := aclRule{
+			allow:    ruleConf.Action == v1alpha1.ACLActionAllow,
+			protocol: ruleConf.Protocol,
+		}
+
+		for _, peer := range ruleConf.Peers {
+			// Short names (eg. "web1") are also valid base64, so only entries of
+			// the length of a key are treated as public keys.
+			if pk, err := config.ParsePublicKey(peer); err == nil {
+				if rule.peerKeys == nil {
+					rule.peerKeys = make(map[types.NoisePublicKey]struct{})
+				}
+				rule.peerKeys[pk] = struct{}{}
+			} else {
+				if rule.peerNames == nil {
+					rule.peerNames = make(map[string]struct{})
+				}
+				rule.peerNames[peer] = struct{}{}
+			}
+		}
+
+		for _, ports := range ruleConf.Ports {
+			start, end, err := config.ParsePortRange(ports)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse ACL ports: %w", err)
+			}
+			rule.ports = append(rule.ports, portRange{start: start, end: end})
+		}
+
+		policy.rules = append(policy.rules, rule)
+	}
+
+	return policy, nil
+}
+
+func (r *aclRule) matches(pk types.NoisePublicKey, name, protocol string, port uint16) bool {
+	if r.peerKeys != nil || r.peerNames != nil {
+		_, keyMatch := r.peerKeys[pk]
+		_, nameMatch := r.peerNames[name]
+		if !keyMatch && !(name != "" && nameMatch) {
+			return false
+		}
+	}
+
+	if r.protocol != "" && r.protocol != protocol {
+		return false
+	}
+
+	if r.ports != nil {
+		// Only TCP and UDP have ports.
+		if protocol != "tcp" && protocol != "udp" {
+			return false
+		}
+
+		for _, ports := range r.ports {
+			if port >= ports.start && port <= ports.end {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// accessControl enforces the access control policy for inbound connections,
+// it inspects packets before they are delivered to the netstack.
+type accessControl struct {
+	logger *slog.Logger
+	pd     *peerDirectory
+	policy atomic.Pointer[aclPolicy]
+
+	// udpFlows tracks outbound UDP flows so that replies can be allowed.
+	udpFlowsMu     sync.Mutex
+	udpFlows       map[udpFlow]time.Time
+	nextFlowExpiry time.Time
+
+	deniedMu sync.Mutex
+	denied   map[types.NoisePublicKey]uint64
+	// deniedLogged is when denied connections to each port were last logged.
+	deniedLogged     map[deniedPort]time.Time
+	nextDeniedExpiry time.Time
+}
+
+type udpFlow struct {
+	local, remote netip.AddrPort
+}
+
+type deniedPort struct {
+	pk       types.NoisePublicKey
+	protocol string
+	port     uint16
+}
+
+func newAccessControl(logger *slog.Logger, pd *peerDirectory) *accessControl {
+	return &accessControl{
+		logger:       logger,
+		pd:           pd,
+		udpFlows:     make(map[udpFlow]time.Time),
+		denied:       make(map[types.NoisePublicKey]uint64),
+		deniedLogged: make(map[deniedPort]time.Time),
+	}
+}
+
+// SetPolicy replaces the access control policy, a nil policy allows all
+// connections.
+func (ac *accessControl) SetPolicy(policy *aclPolicy) {
+	ac.policy.Store(policy)
+}
+
+// Denied returns the number of inbound connections from the peer that
+// have been denied.
+func (ac *accessControl) Denied(pk types.NoisePublicKey) uint64 {
+	ac.deniedMu.Lock()
+	defer ac.deniedMu.Unlock()
+
+	return ac.denied[pk]
+}
+
+// ForgetPeer discards the denied connection count (and log history) of a
+// removed peer.
+func (ac *accessControl) ForgetPeer(pk types.NoisePublicKey) {
+	ac.deniedMu.Lock()
+	defer ac.deniedMu.Unlock()
+
+	delete(ac.denied, pk)
+	for dp := range ac.deniedLogged {
+		if dp.pk == pk {
+			delete(ac.deniedLogged, dp)
+		}
+	}
+}
+
+// TrackOutbound records outbound UDP flows, so that replies are allowed.
+func (ac *accessControl) TrackOutbound(pkt []byte) {
+	if ac.policy.Load() == nil {
+		return
+	}
+
+	flow, ok := parseFlow(pkt)
+	if !ok || flow.protocol != "udp" {
+		return
+	}
+
+	now := time.Now()
+
+	ac.udpFlowsMu.Lock()
+	defer ac.udpFlowsMu.Unlock()
+
+	ac.udpFlows[udpFlow{local: flow.src, remote: flow.dst}] = now.Add(udpFlowTimeout)
+
+	// Periodically clean up expired flows.
+	if now.After(ac.nextFlowExpiry) {
+		for f, expiry := range ac.udpFlows {
+			if now.After(expiry) {
+				delete(ac.udpFlows, f)
+			}
+		}
+		ac.nextFlowExpiry = now.Add(udpFlowTimeout)
+	}
+}
+
+// AllowInbound returns true if the inbound packet from the given peer should
+// be delivered to the netstack.
+func (ac *accessControl) AllowInbound(pk types.NoisePublicKey, pkt []byte) bool {
+	policy := ac.policy.Load()
+	if policy == nil {
+		return true
+	}
+
+	flow, ok := parseFlow(pkt)
+	if !ok {
+		ac.logger.Debug("Dropping unparseable packet", "peer", pk)
+		return false
+	}
+
+	// Only the first packet of a connection is subject to the policy.
+	if !flow.isNew {
+		return true
+	}
+
+	if flow.protocol == "udp" {
+		ac.udpFlowsMu.Lock()
+		key := udpFlow{local: flow.dst, remote: flow.src}
+		expiry, ok := ac.udpFlows[key]
+		isReply := ok && time.Now().Before(expiry)
+		if isReply {
+			ac.udpFlows[key] = time.Now().Add(udpFlowTimeout)
+		}
+		ac.udpFlowsMu.Unlock()
+
+		if isReply {
+			return true
+		}
+	}
+
+	name, _ := ac.pd.LookupPeerNameByPublicKey(pk)
+
+	allow := policy.defaultAllow
+	for i := range policy.rules {
+		if policy.rules[i].matches(pk, name, flow.protocol, flow.dst.Port()) {
+			allow = policy.rules[i].allow
+			break
+		}
+	}
+
+	if !allow && ac.deny(pk, flow) {
+		ac.logger.Warn("Denied inbound connection",
+			"peer", pk, "name", name, "protocol", flow.protocol,
+			"src", flow.src.Addr(), "dst", flow.dst.Addr(), "port", flow.dst.Port())
+	}
+
+	return allow
+}
+
+// deny counts a denied connection, and returns true if it should be logged.
+func (ac *accessControl) deny(pk types.NoisePublicKey, flow flow) bool {
+	now := time.Now()
+
+	ac.deniedMu.Lock()
+	defer ac.deniedMu.Unlock()
+
+	ac.denied[pk]++
+
+	dp := deniedPort{pk: pk, protocol: flow.protocol, port: flow.dst.Port()}
+	if lastLogged, ok := ac.deniedLogged[dp]; ok && now.Sub(lastLogged) < deniedLogInterval {
+		return false
+	}
+
+	ac.deniedLogged[dp] = now
+
+	// Periodically forget ports that haven't been logged recently, eg. after
+	// a port scan.
+	if now.After(ac.nextDeniedExpiry) {
+		for dp, lastLogged := range ac.deniedLogged {
+			if now.Sub(lastLogged) >= deniedLogInterval {
+				delete(ac.deniedLogged, dp)
+			}
+		}
+		ac.nextDeniedExpiry = now.Add(deniedLogInterval)
+	}
+
+	return true
+}
+
+// flow describes the transport layer of a packet.
+type flow struct {
+	// protocol is "tcp", "udp", "icmp" or empty for other protocols.
+	protocol string
+	src, dst netip.AddrPort
+	// isNew is true if the packet could start a new connection (eg. a TCP SYN
+	// or an ICMP echo request).
+	isNew bool
+}
+
+// parseFlow extracts the transport protocol and ports of an IP packet.
+func parseFlow(pkt []byte) (flow, bool) {
+	var f flow
+	var srcAddr, dstAddr netip.Addr
+	var transportProto uint8
+	var payload []byte
+
+	switch pkt[0] >> 4 {
+	case 4:
+		hdr := header.IPv4(pkt)
+		if !hdr.IsValid(len(pkt)) {
+			return f, false
+		}
+
+		// Only the first fragment carries the transport header, subsequent
+		// fragments can't be reassembled without it.
+		if hdr.FragmentOffset() != 0 {
+			return f, true
+		}
+
+		srcAddr = netip.AddrFrom4(hdr.SourceAddress().As4())
+		dstAddr = netip.AddrFrom4(hdr.DestinationAddress().As4())
+		transportProto = hdr.Protocol()
+		payload = hdr.Payload()
+	case 6:
+		hdr := header.IPv6(pkt)
+		if !hdr.IsValid(len(pkt)) {
+			return f, false
+		}
+
+		srcAddr = netip.AddrFrom16(hdr.SourceAddress().As16())
+		dstAddr = netip.AddrFrom16(hdr.DestinationAddress().As16())
+		transportProto = hdr.NextHeader()
+		payload = hdr.Payload()
+
+		// Skip over any extension headers.
+	EXTENSION_HEADERS:
+		for {
+			switch transportProto {
+			case uint8(header.IPv6HopByHopOptionsExtHdrIdentifier),
+				uint8(header.IPv6RoutingExtHdrIdentifier),
+				uint8(header.IPv6DestinationOptionsExtHdrIdentifier):
+				if len(payload) < 2 {
+					return f, false
+				}
+				hdrLen := (int(payload[1]) + 1) * 8
+				if len(payload) < hdrLen {
+					return f, false
+				}
+				transportProto = payload[0]
+				payload = payload[hdrLen:]
+			case uint8(header.IPv6FragmentExtHdrIdentifier):
+				if len(payload) < 8 {
+					return f, false
+				}
+				if binary.BigEndian.Uint16(payload[2:4])>>3 != 0 {
+					return f, true
+				}
+				transportProto = payload[0]
+				payload = payload[8:]
+			default:
+				break EXTENSION_HEADERS
+			}
+		}
+	default:
+		return f, false
+	}
+
+	switch transportProto {
+	case uint8(header.TCPProtocolNumber):
+		if len(payload) < header.TCPMinimumSize {
+			return f, false
+		}
+		tcp := header.TCP(payload)
+		f.protocol = "tcp"
+		f.src = netip.AddrPortFrom(srcAddr, tcp.SourcePort())
+		f.dst = netip.AddrPortFrom(dstAddr, tcp.DestinationPort())
+		f.isNew = tcp.Flags().Contains(header.TCPFlagSyn) && !tcp.Flags().Contains(header.TCPFlagAck)
+	case uint8(header.UDPProtocolNumber):
+		if len(payload) < header.UDPMinimumSize {
+			return f, false
+		}
+		udp := header.UDP(payload)
+		f.protocol = "udp"
+		f.src = netip.AddrPortFrom(srcAddr, udp.SourcePort())
+		f.dst = netip.AddrPortFrom(dstAddr, udp.DestinationPort())
+		f.isNew = true
+	case uint8(header.ICMPv4ProtocolNumber):
+		if len(payload) < header.ICMPv4MinimumSize {
+			return f, false
+		}
+		f.protocol = "icmp"
+		f.src = netip.AddrPortFrom(srcAddr, 0)
+		f.dst = netip.AddrPortFrom(dstAddr, 0)
+		f.isNew = header.ICMPv4(payload).Type() == header.ICMPv4Echo
+	case uint8(header.ICMPv6ProtocolNumber):
+		if len(payload) < header.ICMPv6MinimumSize {
+			return f, false
+		}
+		f.protocol = "icmp"
+		f.src = netip.AddrPortFrom(srcAddr, 0)
+		f.dst = netip.AddrPortFrom(dstAddr, 0)
+		f.isNew = header.ICMPv6(payload).Type() == header.ICMPv6EchoRequest
+	default:
+		f.src = netip.AddrPortFrom(srcAddr, 0)
+		f.dst = netip.AddrPortFrom(dstAddr, 0)
+		f.isNew = true
+	}
+
+	return f, true
+}
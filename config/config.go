@@ -54,7 +54,9 @@ func FromYAML(r io.Reader) (conf *latest.Config, err error) {
 		conf = versionedConf.(*latest.Config)
 	}
 
-	// TODO: validate config.
+	if err := Validate(conf); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
 
 	return conf, nil
 }
@@ -13,9 +13,13 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/go-multierror"
 	"github.com/noisysockets/noisysockets/config"
+	"github.com/noisysockets/noisysockets/config/v1alpha1"
 	"github.com/stretchr/testify/require"
 )
 
@@ -66,3 +70,288 @@ func TestSaveToYAML(t *testing.T) {
 
 	require.Equal(t, conf, conf2)
 }
+
+func TestFromINI(t *testing.T) {
+	t.Run("Fixture", func(t *testing.T) {
+		configFile, err := os.Open("../testdata/wg0.conf")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, configFile.Close())
+		})
+
+		conf, err := config.FromINI(configFile)
+		require.NoError(t, err)
+
+		require.Equal(t, "Config", conf.GetKind())
+		require.Equal(t, "noisysockets.github.com/v1alpha1", conf.GetAPIVersion())
+
+		require.Equal(t, "2FM36K8gizo0pdl/Ap4OBcF2E4RazQGvZqLmD4B4xUU=", conf.PrivateKey)
+		require.Equal(t, uint16(51820), conf.ListenPort)
+		require.Equal(t, []string{"10.7.0.1"}, conf.IPs)
+
+		require.Len(t, conf.Peers, 1)
+		require.Equal(t, "7YVd+U+khir1BQnDULmKA5IoKaj2K6xs/UAt6A2ZOxs=", conf.Peers[0].PublicKey)
+		require.Equal(t, []string{"10.7.0.2"}, conf.Peers[0].IPs)
+	})
+
+	t.Run("All Keys", func(t *testing.T) {
+		conf, err := config.FromINI(strings.NewReader(testINIConfig))
+		require.NoError(t, err)
+
+		require.Equal(t, "SFN1gntnAutVFefwrPDlM1W2/LGWaRSn2hq06TvL2GY=", conf.PrivateKey)
+		require.Equal(t, uint16(12346), conf.ListenPort)
+		require.Equal(t, 1380, conf.MTU)
+		require.Equal(t, []string{"10.7.0.2"}, conf.IPs)
+		require.Equal(t, []string{"10.7.0.1"}, conf.DNSServers)
+
+		require.Len(t, conf.Peers, 1)
+		require.Equal(t, "6cvvZyj+EVL4DHjUKeVF7EUBfgR2mJO4php2Gdv9FVw=", conf.Peers[0].PublicKey)
+		require.Equal(t, "BseiGDUh6xPjbLNjKeQuJzkMcI1b6wxwHgBaNrwWhFE=", conf.Peers[0].PresharedKey)
+		require.Equal(t, "127.0.0.1:12345", conf.Peers[0].Endpoint)
+		require.Equal(t, []string{"10.7.0.1", "0.0.0.0/0"}, conf.Peers[0].IPs)
+		require.False(t, conf.Peers[0].DefaultGateway)
+		require.NotNil(t, conf.Peers[0].PersistentKeepalive)
+		require.Equal(t, 25*time.Second, *conf.Peers[0].PersistentKeepalive)
+	})
+
+	t.Run("Address Prefix", func(t *testing.T) {
+		_, err := config.FromINI(strings.NewReader(`[Interface]
+PrivateKey = SFN1gntnAutVFefwrPDlM1W2/LGWaRSn2hq06TvL2GY=
+Address = 10.7.0.2/24
+`))
+		require.ErrorContains(t, err, "only host addresses are supported")
+	})
+
+	t.Run("Unknown Key", func(t *testing.T) {
+		_, err := config.FromINI(strings.NewReader(`[Interface]
+PrivateKey = SFN1gntnAutVFefwrPDlM1W2/LGWaRSn2hq06TvL2GY=
+Address = 10.7.0.2
+ListenPrt = 12346
+`))
+		require.ErrorContains(t, err, `unknown interface key "listenprt"`)
+
+		_, err = config.FromINI(strings.NewReader(`[Interface]
+PrivateKey = SFN1gntnAutVFefwrPDlM1W2/LGWaRSn2hq06TvL2GY=
+Address = 10.7.0.2
+
+[Peer]
+PublicKey = 6cvvZyj+EVL4DHjUKeVF7EUBfgR2mJO4php2Gdv9FVw=
+AllowedIP = 10.7.0.1/32
+`))
+		require.ErrorContains(t, err, `unknown peer key "allowedip"`)
+	})
+}
+
+func TestSaveToINI(t *testing.T) {
+	conf, err := config.FromINI(strings.NewReader(testINIConfig))
+	require.NoError(t, err)
+
+	var sb strings.Builder
+	require.NoError(t, config.SaveToINI(&sb, conf))
+
+	conf2, err := config.FromINI(strings.NewReader(sb.String()))
+	require.NoError(t, err)
+
+	require.Equal(t, conf, conf2)
+}
+
+func TestINIPersistentKeepalive(t *testing.T) {
+	conf, err := config.FromINI(strings.NewReader(`[Interface]
+PrivateKey = SFN1gntnAutVFefwrPDlM1W2/LGWaRSn2hq06TvL2GY=
+Address = 10.7.0.2
+
+[Peer]
+PublicKey = 6cvvZyj+EVL4DHjUKeVF7EUBfgR2mJO4php2Gdv9FVw=
+AllowedIPs = 10.7.0.1/32
+`))
+	require.NoError(t, err)
+
+	// Keepalives are off unless enabled, as in wg-quick.
+	require.Len(t, conf.Peers, 1)
+	require.NotNil(t, conf.Peers[0].PersistentKeepalive)
+	require.Zero(t, *conf.Peers[0].PersistentKeepalive)
+
+	var sb strings.Builder
+	require.NoError(t, config.SaveToINI(&sb, conf))
+	require.Contains(t, sb.String(), "PersistentKeepalive = off\n")
+
+	// The effective default is written when not specified.
+	conf.Peers[0].PersistentKeepalive = nil
+
+	sb.Reset()
+	require.NoError(t, config.SaveToINI(&sb, conf))
+	require.Contains(t, sb.String(), "PersistentKeepalive = 25\n")
+}
+
+func TestValidate(t *testing.T) {
+	conf, err := config.FromYAML(strings.NewReader(`apiVersion: noisysockets.github.com/v1alpha1
+kind: Config
+name: client
+privateKey: SFN1gntnAutVFefwrPDlM1W2/LGWaRSn2hq06TvL2GY=
+ips:
+- 10.7.0.2
+mtu: 100
+dnsServers:
+- 10.7.0.1:0
+- tcp://10.7.0.1
+- quic://10.7.0.1
+dnsTimeout: -1s
+nameServer:
+  zone: bad..zone
+peers:
+- name: server
+  publicKey: 6cvvZyj+EVL4DHjUKeVF7EUBfgR2mJO4php2Gdv9FVw=
+  endpoint: 127.0.0.1:12345
+  ips:
+  - 10.7.0.1
+  defaultGateway: true
+- name: client
+  publicKey: 6cvvZyj+EVL4DHjUKeVF7EUBfgR2mJO4php2Gdv9FVw=
+  endpoint: 127.0.0.1
+  ips:
+  - 10.7.0.2
+- publicKey: not-a-key
+  ips:
+  - 10.7.0.0/33
+  - 0.0.0.0/0
+- publicKey: kGKaFDuGDsh/FDK9/tfVLOo+jdfz7CMBpRWsbdYXXHQ=
+- publicKey: YWJj
+  presharedKey: kGKaFDuGDsh/FDK9/tfVLOo+jdfz7CMBpRWsbdYXXHQ9kGKaFDuGDsh/FDK9/tfVLOo+jdfz7CMBpRWsbdYXXHQ=
+  ips:
+  - 10.7.0.4
+acl:
+  defaultAction: reject
+  rules:
+  - action: allow
+    peers:
+    - server
+    - web1
+    - kGKaFDuGDsh/FDK9/tfVLOo+jdfz7CMBpRWsbdYXXHQ=
+    - uuRqUuoI6k6SE1pe/oqyrwiwl8lgSjhx9wmyHxyNcGA=
+    protocol: tcp
+    ports:
+    - "80"
+    - 8000-8999
+  - action: permit
+    protocol: icmp
+    ports:
+    - "0"
+  - action: deny
+    protocol: sctp
+    ports:
+    - 90-80
+portForwards:
+- name: web
+  direction: inbound
+  listenAddress: :80
+  targetAddress: localhost:8080
+- name: web
+  direction: sideways
+  protocol: sctp
+  listenAddress: "80"
+  targetAddress: localhost
+  udpIdleTimeout: 30s
+`))
+	require.Nil(t, conf)
+
+	var result *multierror.Error
+	require.ErrorAs(t, err, &result)
+
+	var fields []string
+	for _, err := range result.Errors {
+		var fieldErr *config.FieldError
+		require.ErrorAs(t, err, &fieldErr)
+
+		fields = append(fields, fieldErr.Field)
+	}
+
+	require.Equal(t, []string{
+		"mtu",
+		"dnsServers[0]",
+		"dnsServers[2]",
+		"dnsTimeout",
+		"nameServer.zone",
+		"peers[1].name",
+		"peers[1].endpoint",
+		"peers[1].publicKey",
+		"peers[1].ips[0]",
+		"peers[2].publicKey",
+		"peers[2].ips[0]",
+		"peers[2].ips[1]",
+		"peers[3].ips",
+		"peers[4].publicKey",
+		"peers[4].presharedKey",
+		"acl.defaultAction",
+		"acl.rules[0].peers[1]",
+		"acl.rules[0].peers[3]",
+		"acl.rules[1].action",
+		"acl.rules[1].ports",
+		"acl.rules[1].ports[0]",
+		"acl.rules[2].protocol",
+		"acl.rules[2].ports[0]",
+		"portForwards[1].name",
+		"portForwards[1].direction",
+		"portForwards[1].protocol",
+		"portForwards[1].listenAddress",
+		"portForwards[1].targetAddress",
+		"portForwards[1].udpIdleTimeout",
+	}, fields)
+}
+
+func TestValidatePeer(t *testing.T) {
+	keepalive := 1500 * time.Millisecond
+	err := config.ValidatePeer(v1alpha1.PeerConfig{
+		PublicKey:           "6cvvZyj+EVL4DHjUKeVF7EUBfgR2mJO4php2Gdv9FVw=",
+		PresharedKey:        "YWJj",
+		IPs:                 []string{"10.7.0.1"},
+		PersistentKeepalive: &keepalive,
+	})
+
+	var result *multierror.Error
+	require.ErrorAs(t, err, &result)
+
+	var fields []string
+	for _, err := range result.Errors {
+		var fieldErr *config.FieldError
+		require.ErrorAs(t, err, &fieldErr)
+
+		fields = append(fields, fieldErr.Field)
+	}
+
+	require.Equal(t, []string{"peer.presharedKey", "peer.persistentKeepalive"}, fields)
+}
+
+func TestValidateNameServerZone(t *testing.T) {
+	for _, zone := range []string{".", "bad..zone"} {
+		_, err := config.FromYAML(strings.NewReader(`apiVersion: noisysockets.github.com/v1alpha1
+kind: Config
+privateKey: SFN1gntnAutVFefwrPDlM1W2/LGWaRSn2hq06TvL2GY=
+ips:
+- 10.7.0.2
+nameServer:
+  zone: "` + zone + `"
+`))
+
+		var fieldErr *config.FieldError
+		require.ErrorAs(t, err, &fieldErr, zone)
+		require.Equal(t, "nameServer.zone", fieldErr.Field)
+	}
+}
+
+// testINIConfig uses every supported key, along with keys that are ignored.
+const testINIConfig = `[Interface]
+# The private key of this peer.
+PrivateKey = SFN1gntnAutVFefwrPDlM1W2/LGWaRSn2hq06TvL2GY=
+Address = 10.7.0.2/32
+ListenPort = 12346
+MTU = 1380
+DNS = 10.7.0.1, example.com
+PostUp = echo "Hello, world!"
+
+[Peer]
+PublicKey = 6cvvZyj+EVL4DHjUKeVF7EUBfgR2mJO4php2Gdv9FVw=
+PresharedKey = BseiGDUh6xPjbLNjKeQuJzkMcI1b6wxwHgBaNrwWhFE=
+AllowedIPs = 10.7.0.1/32, 0.0.0.0/0
+Endpoint = 127.0.0.1:12345
+PersistentKeepalive = 25
+`
@@ -0,0 +1,325 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/noisysockets/noisysockets/config/types"
+	latest "github.com/noisysockets/noisysockets/config/v1alpha1"
+	"github.com/noisysockets/noisysockets/internal/dns/serveraddr"
+)
+
+// FromINI reads a wg-quick / wg(8) style INI configuration file from the given
+// reader and returns a config object. wg-quick keys that have no equivalent in
+// the config (eg. PostUp, Table) are ignored, any other unknown key is an error.
+func FromINI(r io.Reader) (*latest.Config, error) {
+	conf := &latest.Config{
+		TypeMeta: types.TypeMeta{
+			Kind:       "Config",
+			APIVersion: latest.ApiVersion,
+		},
+	}
+
+	var section string
+	var peerConf *latest.PeerConfig
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			switch section {
+			case "interface":
+			case "peer":
+				// Keepalives are off unless enabled, as in wg-quick.
+				var keepalive time.Duration
+				conf.Peers = append(conf.Peers, latest.PeerConfig{
+					PersistentKeepalive: &keepalive,
+				})
+				peerConf = &conf.Peers[len(conf.Peers)-1]
+			default:
+				return nil, fmt.Errorf("line %d: unknown section %q", lineNumber, section)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNumber)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		var err error
+		switch section {
+		case "interface":
+			err = parseINIInterfaceKey(conf, key, value)
+		case "peer":
+			err = parseINIPeerKey(peerConf, key, value)
+		default:
+			err = fmt.Errorf("key %q outside of section", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read config from reader: %w", err)
+	}
+
+	if err := Validate(conf); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return conf, nil
+}
+
+// SaveToINI writes the given config object to the given writer in the
+// wg-quick / wg(8) INI format. Peer names are not representable in the
+// INI format and are omitted.
+func SaveToINI(w io.Writer, versionedConf types.Config) error {
+	var conf *latest.Config
+	if versionedConf.GetAPIVersion() != latest.ApiVersion {
+		var err error
+		conf, err = migrate(versionedConf)
+		if err != nil {
+			return fmt.Errorf("failed to migrate config: %w", err)
+		}
+	} else {
+		conf = versionedConf.(*latest.Config)
+	}
+
+	var sb strings.Builder
+
+	sb.WriteString("[Interface]\n")
+	fmt.Fprintf(&sb, "PrivateKey = %s\n", conf.PrivateKey)
+
+	if len(conf.IPs) > 0 {
+		var addrs []string
+		for _, ip := range conf.IPs {
+			prefix, err := hostPrefix(ip)
+			if err != nil {
+				return fmt.Errorf("invalid address %q: %w", ip, err)
+			}
+			addrs = append(addrs, prefix.String())
+		}
+		fmt.Fprintf(&sb, "Address = %s\n", strings.Join(addrs, ", "))
+	}
+
+	if conf.ListenPort != 0 {
+		fmt.Fprintf(&sb, "ListenPort = %d\n", conf.ListenPort)
+	}
+
+	if conf.MTU != 0 {
+		fmt.Fprintf(&sb, "MTU = %d\n", conf.MTU)
+	}
+
+	if len(conf.DNSServers) > 0 {
+		var dnsServers []string
+		for _, dnsServer := range conf.DNSServers {
+			serverAddr, err := serveraddr.Parse(dnsServer)
+			if err != nil {
+				return fmt.Errorf("dns server %q: %w", dnsServer, err)
+			}
+
+			// wg-quick only supports DNS servers reachable over UDP (with TCP
+			// fallback) on the standard port.
+			if serverAddr.Transport == serveraddr.TransportTCP {
+				return fmt.Errorf("dns server %q: tcp transport is not supported", dnsServer)
+			}
+			if serverAddr.Port() != 0 && serverAddr.Port() != 53 {
+				return fmt.Errorf("dns server %q: non-standard ports are not supported", dnsServer)
+			}
+			dnsServers = append(dnsServers, serverAddr.Addr().String())
+		}
+		fmt.Fprintf(&sb, "DNS = %s\n", strings.Join(dnsServers, ", "))
+	}
+
+	for _, peerConf := range conf.Peers {
+		sb.WriteString("\n[Peer]\n")
+		fmt.Fprintf(&sb, "PublicKey = %s\n", peerConf.PublicKey)
+
+		if peerConf.PresharedKey != "" {
+			fmt.Fprintf(&sb, "PresharedKey = %s\n", peerConf.PresharedKey)
+		}
+
+		var allowedIPs []string
+		for _, ip := range peerConf.IPs {
+			// Allowed IPs may be either prefixes or host addresses.
+			prefix, err := netip.ParsePrefix(ip)
+			if err != nil {
+				prefix, err = hostPrefix(ip)
+				if err != nil {
+					return fmt.Errorf("invalid peer address %q: %w", ip, err)
+				}
+			}
+			allowedIPs = append(allowedIPs, prefix.String())
+		}
+		if peerConf.DefaultGateway {
+			allowedIPs = append(allowedIPs, "0.0.0.0/0", "::/0")
+		}
+		if len(allowedIPs) > 0 {
+			fmt.Fprintf(&sb, "AllowedIPs = %s\n", strings.Join(allowedIPs, ", "))
+		}
+
+		if peerConf.Endpoint != "" {
+			fmt.Fprintf(&sb, "Endpoint = %s\n", peerConf.Endpoint)
+		}
+
+		// Always write the effective interval, as the default differs from
+		// wg-quick (where keepalives are off unless enabled).
+		keepalive := latest.DefaultPersistentKeepalive
+		if peerConf.PersistentKeepalive != nil {
+			keepalive = *peerConf.PersistentKeepalive
+		}
+		if keepalive == 0 {
+			sb.WriteString("PersistentKeepalive = off\n")
+		} else {
+			fmt.Fprintf(&sb, "PersistentKeepalive = %d\n", int(keepalive.Seconds()))
+		}
+	}
+
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+
+	return nil
+}
+
+// iniIgnoredInterfaceKeys are the wg-quick / wg(8) interface keys that have
+// no equivalent in the config.
+var iniIgnoredInterfaceKeys = []string{
+	"fwmark", "postdown", "postup", "predown", "preup", "saveconfig", "table",
+}
+
+func parseINIInterfaceKey(conf *latest.Config, key, value string) error {
+	switch key {
+	case "privatekey":
+		conf.PrivateKey = value
+	case "listenport":
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid listen port %q: %w", value, err)
+		}
+		conf.ListenPort = uint16(port)
+	case "mtu":
+		mtu, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid mtu %q: %w", value, err)
+		}
+		conf.MTU = int(mtu)
+	case "address":
+		for _, addr := range splitINIList(value) {
+			prefix, err := netip.ParsePrefix(addr)
+			if err != nil {
+				// Addresses without a prefix length are also accepted.
+				ip, err := netip.ParseAddr(addr)
+				if err != nil {
+					return fmt.Errorf("invalid address %q: %w", addr, err)
+				}
+				prefix = netip.PrefixFrom(ip, ip.BitLen())
+			}
+
+			// wg-quick adds a route for the prefix, but here routes are derived
+			// from the allowed IPs of peers, so the prefix length can't be kept.
+			if !prefix.IsSingleIP() {
+				return fmt.Errorf("invalid address %q: only host addresses are supported, use the allowed ips of peers for routes", addr)
+			}
+			conf.IPs = append(conf.IPs, prefix.Addr().String())
+		}
+	case "dns":
+		for _, dnsServer := range splitINIList(value) {
+			// Non IP entries are search domains, which we don't support.
+			if _, err := netip.ParseAddr(dnsServer); err != nil {
+				continue
+			}
+			conf.DNSServers = append(conf.DNSServers, dnsServer)
+		}
+	default:
+		if !slices.Contains(iniIgnoredInterfaceKeys, key) {
+			return fmt.Errorf("unknown interface key %q", key)
+		}
+	}
+
+	return nil
+}
+
+func parseINIPeerKey(peerConf *latest.PeerConfig, key, value string) error {
+	switch key {
+	case "publickey":
+		peerConf.PublicKey = value
+	case "presharedkey":
+		peerConf.PresharedKey = value
+	case "endpoint":
+		peerConf.Endpoint = value
+	case "allowedips":
+		for _, allowedIP := range splitINIList(value) {
+			prefix, err := netip.ParsePrefix(allowedIP)
+			if err != nil {
+				return fmt.Errorf("invalid allowed ip %q: %w", allowedIP, err)
+			}
+
+			if prefix.IsSingleIP() {
+				peerConf.IPs = append(peerConf.IPs, prefix.Addr().String())
+			} else {
+				peerConf.IPs = append(peerConf.IPs, prefix.Masked().String())
+			}
+		}
+	case "persistentkeepalive":
+		var interval time.Duration
+		if value != "off" {
+			seconds, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return fmt.Errorf("invalid persistent keepalive %q: %w", value, err)
+			}
+			interval = time.Duration(seconds) * time.Second
+		}
+		peerConf.PersistentKeepalive = &interval
+	default:
+		return fmt.Errorf("unknown peer key %q", key)
+	}
+
+	return nil
+}
+
+func splitINIList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func hostPrefix(ip string) (netip.Prefix, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
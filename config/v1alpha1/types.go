@@ -11,12 +11,17 @@ package v1alpha1
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/noisysockets/noisysockets/config/types"
 )
 
 const ApiVersion = "noisysockets.github.com/v1alpha1"
 
+// DefaultPersistentKeepalive is the keepalive interval used for peers that
+// don't specify one.
+const DefaultPersistentKeepalive = 25 * time.Second
+
 // Config is the configuration for a NoisySockets network.
 // It is analogous to the configuration for a WireGuard interface.
 type Config struct {
@@ -26,14 +31,43 @@ type Config struct {
 	// ListenPort is an optional port on which to listen for incoming packets.
 	// If not specified, one will be chosen randomly.
 	ListenPort uint16 `yaml:"listenPort,omitempty" mapstructure:"listenPort,omitempty"`
+	// MTU is the optional maximum transmission unit of the tunnel interface.
+	// If not specified, a default of 1420 is used.
+	MTU int `yaml:"mtu,omitempty" mapstructure:"mtu,omitempty"`
 	// PrivateKey is the private key for this peer.
 	PrivateKey string `yaml:"privateKey" mapstructure:"privateKey"`
 	// IPs is a list of IP addresses assigned to this peer.
 	IPs []string `yaml:"ips,omitempty" mapstructure:"ips,omitempty"`
 	// DNSServers is an optional list of DNS servers to use for host resolution.
+	// Each entry is an IP address with an optional port, and may be prefixed
+	// with "udp://" (the default) or "tcp://" to select the transport used to
+	// query the server.
 	DNSServers []string `yaml:"dnsServers,omitempty" mapstructure:"dnsServers,omitempty"`
+	// DNSTimeout is the timeout for each attempt to query a DNS server, if a
+	// server doesn't respond in time the next server is tried. Defaults to 2s.
+	DNSTimeout time.Duration `yaml:"dnsTimeout,omitempty" mapstructure:"dnsTimeout,omitempty"`
+	// NameServer is an optional configuration for an embedded DNS server,
+	// listening on port 53 of this peer's IPs, that answers queries for the
+	// names of peers. This allows other WireGuard clients on the network to
+	// resolve peer names.
+	NameServer *NameServerConfig `yaml:"nameServer,omitempty" mapstructure:"nameServer,omitempty"`
 	// Peers is a list of known peers to which we can send and receive packets.
 	Peers []PeerConfig `yaml:"peers,omitempty" mapstructure:"peers,omitempty"`
+	// ACL is an optional access control policy for inbound connections from
+	// peers. If not specified, peers can connect to any port.
+	ACL *ACLConfig `yaml:"acl,omitempty" mapstructure:"acl,omitempty"`
+	// PortForwards is an optional list of ports to forward between the host
+	// and the network.
+	PortForwards []PortForwardConfig `yaml:"portForwards,omitempty" mapstructure:"portForwards,omitempty"`
+}
+
+// NameServerConfig is the configuration for the embedded DNS server.
+type NameServerConfig struct {
+	// Zone is the optional domain under which peer names are served, eg. a
+	// peer named "server" is resolvable as "server.internal". If not
+	// specified, "internal" is used. Queries for names outside of the zone
+	// are forwarded to the configured DNS servers.
+	Zone string `yaml:"zone,omitempty" mapstructure:"zone,omitempty"`
 }
 
 // PeerConfig is the configuration for a known wireguard peer.
@@ -42,14 +76,98 @@ type PeerConfig struct {
 	Name string `yaml:"name,omitempty" mapstructure:"name,omitempty"`
 	// PublicKey is the public key of the peer.
 	PublicKey string `yaml:"publicKey" mapstructure:"publicKey"`
+	// PresharedKey is an optional symmetric key that is mixed into the handshake,
+	// adding an additional layer of post-quantum resistance.
+	PresharedKey string `yaml:"presharedKey,omitempty" mapstructure:"presharedKey,omitempty"`
 	// Endpoint is an optional endpoint to which the peer's packets should be sent.
 	// If not specified, the peers endpoint will be determined from received packets.
 	Endpoint string `yaml:"endpoint,omitempty" mapstructure:"endpoint,omitempty"`
-	// IPs is a list of IP addresses assigned to the peer, this is optional for gateways.
-	// Traffic with a source IP not in this list will be dropped.
+	// IPs is a list of IP addresses and/or CIDR prefixes (AllowedIPs) routed to
+	// the peer, this is optional for gateways. Traffic is routed to the peer with
+	// the longest matching prefix, and traffic from the peer with a source IP not
+	// in this list will be dropped.
 	IPs []string `yaml:"ips,omitempty" mapstructure:"ips,omitempty"`
 	// DefaultGateway indicates this peer should be used as the default gateway for traffic.
+	// It is shorthand for including 0.0.0.0/0 and ::/0 in IPs.
 	DefaultGateway bool `yaml:"defaultGateway,omitempty" mapstructure:"defaultGateway,omitempty"`
+	// PersistentKeepalive is the optional interval at which keepalives are sent
+	// to the peer to keep NAT mappings valid. If not specified, a default of 25
+	// seconds is used. An interval of zero disables keepalives.
+	PersistentKeepalive *time.Duration `yaml:"persistentKeepalive,omitempty" mapstructure:"persistentKeepalive,omitempty"`
+	// AdaptiveKeepalive stretches the keepalive interval (up to 45 seconds) while
+	// traffic from the peer is observed to arrive after longer idle periods,
+	// reducing idle traffic. The interval is reset to PersistentKeepalive when
+	// the mapping appears to have expired.
+	AdaptiveKeepalive bool `yaml:"adaptiveKeepalive,omitempty" mapstructure:"adaptiveKeepalive,omitempty"`
+}
+
+// ACLAction is the action taken for connections matching an ACL rule.
+type ACLAction string
+
+const (
+	// ACLActionAllow allows matching connections.
+	ACLActionAllow ACLAction = "allow"
+	// ACLActionDeny silently drops matching connections.
+	ACLActionDeny ACLAction = "deny"
+)
+
+// ACLConfig is an access control policy for inbound connections from peers.
+// Replies to connections initiated by this peer are always allowed.
+type ACLConfig struct {
+	// DefaultAction is the action taken when no rule matches.
+	// If not specified, connections are allowed.
+	DefaultAction ACLAction `yaml:"defaultAction,omitempty" mapstructure:"defaultAction,omitempty"`
+	// Rules is a list of rules that are evaluated in order, the first matching
+	// rule determines the action taken.
+	Rules []ACLRule `yaml:"rules,omitempty" mapstructure:"rules,omitempty"`
+}
+
+// ACLRule matches inbound connections by peer, protocol and destination port.
+type ACLRule struct {
+	// Action is the action taken for matching connections.
+	Action ACLAction `yaml:"action" mapstructure:"action"`
+	// Peers is an optional list of peer names and/or public keys the rule
+	// applies to. If not specified, the rule applies to all peers.
+	Peers []string `yaml:"peers,omitempty" mapstructure:"peers,omitempty"`
+	// Protocol is the optional protocol the rule applies to, one of "tcp",
+	// "udp" or "icmp". If not specified, the rule applies to all protocols.
+	Protocol string `yaml:"protocol,omitempty" mapstructure:"protocol,omitempty"`
+	// Ports is an optional list of destination ports and/or port ranges
+	// (eg. "8000-8999") the rule applies to. If not specified, the rule
+	// applies to all ports.
+	Ports []string `yaml:"ports,omitempty" mapstructure:"ports,omitempty"`
+}
+
+// PortForwardDirection is the direction in which a port is forwarded.
+type PortForwardDirection string
+
+const (
+	// PortForwardDirectionInbound listens on the network and forwards to an
+	// address on the host, exposing a host service to peers.
+	PortForwardDirectionInbound PortForwardDirection = "inbound"
+	// PortForwardDirectionOutbound listens on the host and forwards to an
+	// address on the network, exposing a service on the network to the host.
+	PortForwardDirectionOutbound PortForwardDirection = "outbound"
+)
+
+// PortForwardConfig is the configuration for a forwarded port.
+type PortForwardConfig struct {
+	// Name uniquely identifies the port forward.
+	Name string `yaml:"name" mapstructure:"name"`
+	// Direction is the direction in which the port is forwarded.
+	Direction PortForwardDirection `yaml:"direction" mapstructure:"direction"`
+	// Protocol is the optional protocol to forward, either "tcp" or "udp".
+	// If not specified, "tcp" is used.
+	Protocol string `yaml:"protocol,omitempty" mapstructure:"protocol,omitempty"`
+	// ListenAddress is the address on which to listen (eg. ":8080"). An
+	// empty host listens on all addresses.
+	ListenAddress string `yaml:"listenAddress" mapstructure:"listenAddress"`
+	// TargetAddress is the address to forward to (eg. "localhost:80" or
+	// "server:80").
+	TargetAddress string `yaml:"targetAddress" mapstructure:"targetAddress"`
+	// UDPIdleTimeout is how long a UDP session is kept open without any
+	// traffic. If not specified, a default of 2 minutes is used.
+	UDPIdleTimeout time.Duration `yaml:"udpIdleTimeout,omitempty" mapstructure:"udpIdleTimeout,omitempty"`
 }
 
 func (c Config) GetKind() string {
@@ -0,0 +1,498 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	stdnet "net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	latest "github.com/noisysockets/noisysockets/config/v1alpha1"
+	"github.com/noisysockets/noisysockets/internal/dns/serveraddr"
+	"github.com/noisysockets/noisysockets/types"
+)
+
+const (
+	// minMTU is the minimum MTU of an IPv4 network.
+	minMTU = 576
+	// minMTUv6 is the minimum MTU of an IPv6 network.
+	minMTUv6 = 1280
+	// maxMTU leaves room for the WireGuard (32 bytes), UDP (8 bytes) and
+	// IPv6 (40 bytes) headers in a maximum sized datagram.
+	maxMTU = 65535 - 80
+	// maxPersistentKeepalive is the maximum keepalive interval supported by
+	// WireGuard.
+	maxPersistentKeepalive = 65535 * time.Second
+	// maxDomainNameLength is the maximum length of a domain name in its
+	// presentation format, without the trailing dot.
+	maxDomainNameLength = 253
+	// maxLabelLength is the maximum length of a label of a domain name.
+	maxLabelLength = 63
+)
+
+// FieldError is a problem with a specific field of a config.
+type FieldError struct {
+	// Field is the path to the field, eg. "peers[2].publicKey".
+	Field string
+	// Err describes the problem with the field.
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Field, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Validate checks the given config for problems. All problems found are
+// returned at once as a *multierror.Error containing a *FieldError for each
+// problem.
+func Validate(conf *latest.Config) error {
+	v := &validator{}
+
+	var localPublicKey types.NoisePublicKey
+	if conf.PrivateKey == "" {
+		v.addf("privateKey", "is required")
+	} else {
+		var privateKey types.NoisePrivateKey
+		if err := parseKey(privateKey[:], conf.PrivateKey); err != nil {
+			v.add("privateKey", err)
+		} else {
+			localPublicKey = privateKey.PublicKey()
+		}
+	}
+
+	if len(conf.IPs) == 0 {
+		v.addf("ips", "at least one address is required")
+	}
+
+	localAddrs := make(map[netip.Addr]string)
+	for i, ip := range conf.IPs {
+		field := fmt.Sprintf("ips[%d]", i)
+
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			v.add(field, fmt.Errorf("malformed address: %w", err))
+			continue
+		}
+
+		if existing, ok := localAddrs[addr]; ok {
+			v.addf(field, "duplicate address %s (also %s)", addr, existing)
+			continue
+		}
+		localAddrs[addr] = field
+	}
+
+	if conf.MTU != 0 {
+		hasV6 := false
+		for addr := range localAddrs {
+			hasV6 = hasV6 || addr.Is6()
+		}
+
+		switch {
+		case conf.MTU < minMTU || conf.MTU > maxMTU:
+			v.addf("mtu", "must be between %d and %d", minMTU, maxMTU)
+		case hasV6 && conf.MTU < minMTUv6:
+			v.addf("mtu", "must be at least %d when using IPv6", minMTUv6)
+		}
+	}
+
+	for i, dnsServer := range conf.DNSServers {
+		field := fmt.Sprintf("dnsServers[%d]", i)
+
+		if _, err := serveraddr.Parse(dnsServer); err != nil {
+			v.add(field, fmt.Errorf("malformed address: %w", err))
+		}
+	}
+
+	if conf.DNSTimeout < 0 {
+		v.addf("dnsTimeout", "must be positive")
+	}
+
+	if conf.NameServer != nil && conf.NameServer.Zone != "" {
+		if err := validateZone(conf.NameServer.Zone); err != nil {
+			v.add("nameServer.zone", err)
+		}
+	}
+
+	names := make(map[string]string)
+	if conf.Name != "" {
+		names[conf.Name] = "name"
+	}
+	publicKeys := make(map[types.NoisePublicKey]string)
+	prefixes := make(map[netip.Prefix]string)
+
+	for i, peerConf := range conf.Peers {
+		field := fmt.Sprintf("peers[%d]", i)
+
+		if peerConf.Name != "" {
+			if existing, ok := names[peerConf.Name]; ok {
+				v.addf(field+".name", "duplicate name %q (also %s)", peerConf.Name, existing)
+			} else {
+				names[peerConf.Name] = field + ".name"
+			}
+		}
+
+		validatePeer(v, field, peerConf)
+
+		var publicKey types.NoisePublicKey
+		if err := parseKey(publicKey[:], peerConf.PublicKey); err == nil {
+			if publicKey == localPublicKey {
+				v.addf(field+".publicKey", "is the public key of the local node")
+			} else if existing, ok := publicKeys[publicKey]; ok {
+				v.addf(field+".publicKey", "duplicate public key (also %s)", existing)
+			} else {
+				publicKeys[publicKey] = field + ".publicKey"
+			}
+		}
+
+		for j, ip := range peerConf.IPs {
+			ipField := fmt.Sprintf("%s.ips[%d]", field, j)
+
+			prefix, err := ParsePrefix(ip)
+			if err != nil {
+				continue
+			}
+
+			if prefix.IsSingleIP() {
+				if existing, ok := localAddrs[prefix.Addr()]; ok {
+					v.addf(ipField, "address %s overlaps with the local node (%s)", prefix.Addr(), existing)
+					continue
+				}
+			}
+
+			if existing, ok := prefixes[prefix]; ok {
+				v.addf(ipField, "duplicate prefix %s (also %s)", prefix, existing)
+				continue
+			}
+			prefixes[prefix] = ipField
+		}
+
+		// A default gateway owns the default routes.
+		if peerConf.DefaultGateway {
+			for _, prefix := range []netip.Prefix{
+				netip.PrefixFrom(netip.IPv4Unspecified(), 0),
+				netip.PrefixFrom(netip.IPv6Unspecified(), 0),
+			} {
+				if existing, ok := prefixes[prefix]; ok {
+					v.addf(field+".defaultGateway", "multiple default gateways (also %s)", existing)
+					break
+				}
+				prefixes[prefix] = field + ".defaultGateway"
+			}
+		}
+	}
+
+	if conf.ACL != nil {
+		validateACL(v, conf.ACL, conf.Peers)
+	}
+
+	validatePortForwards(v, conf.PortForwards)
+
+	return v.result.ErrorOrNil()
+}
+
+// ValidatePeer checks the given peer config for problems, as with Validate.
+// Conflicts with other peers are not checked.
+func ValidatePeer(peerConf latest.PeerConfig) error {
+	v := &validator{}
+	validatePeer(v, "peer", peerConf)
+	return v.result.ErrorOrNil()
+}
+
+func validatePeer(v *validator, field string, peerConf latest.PeerConfig) {
+	if peerConf.PublicKey == "" {
+		v.addf(field+".publicKey", "is required")
+	} else {
+		var publicKey types.NoisePublicKey
+		if err := parseKey(publicKey[:], peerConf.PublicKey); err != nil {
+			v.add(field+".publicKey", err)
+		}
+	}
+
+	if peerConf.PresharedKey != "" {
+		var presharedKey types.NoisePresharedKey
+		if err := parseKey(presharedKey[:], peerConf.PresharedKey); err != nil {
+			v.add(field+".presharedKey", err)
+		}
+	}
+
+	if peerConf.Endpoint != "" {
+		if err := validateEndpoint(peerConf.Endpoint); err != nil {
+			v.add(field+".endpoint", err)
+		}
+	}
+
+	if len(peerConf.IPs) == 0 && !peerConf.DefaultGateway {
+		v.addf(field+".ips", "at least one address is required unless the peer is a default gateway")
+	}
+
+	for j, ip := range peerConf.IPs {
+		if _, err := ParsePrefix(ip); err != nil {
+			v.add(fmt.Sprintf("%s.ips[%d]", field, j), fmt.Errorf("malformed address or prefix: %w", err))
+		}
+	}
+
+	if peerConf.PersistentKeepalive != nil {
+		interval := *peerConf.PersistentKeepalive
+		switch {
+		case interval < 0 || interval > maxPersistentKeepalive:
+			v.addf(field+".persistentKeepalive", "must be between 0s and %s", maxPersistentKeepalive)
+		case interval%time.Second != 0:
+			v.addf(field+".persistentKeepalive", "must be a whole number of seconds")
+		}
+	}
+
+	if peerConf.AdaptiveKeepalive && peerConf.PersistentKeepalive != nil && *peerConf.PersistentKeepalive == 0 {
+		v.addf(field+".adaptiveKeepalive", "requires persistent keepalives to be enabled")
+	}
+}
+
+func validateACL(v *validator, acl *latest.ACLConfig, peers []latest.PeerConfig) {
+	peerNames := make(map[string]struct{})
+	peerKeys := make(map[types.NoisePublicKey]struct{})
+	for _, peerConf := range peers {
+		if peerConf.Name != "" {
+			peerNames[peerConf.Name] = struct{}{}
+		}
+		if pk, err := ParsePublicKey(peerConf.PublicKey); err == nil {
+			peerKeys[pk] = struct{}{}
+		}
+	}
+
+	switch acl.DefaultAction {
+	case "", latest.ACLActionAllow, latest.ACLActionDeny:
+	default:
+		v.addf("acl.defaultAction", "must be %q or %q", latest.ACLActionAllow, latest.ACLActionDeny)
+	}
+
+	for i, rule := range acl.Rules {
+		field := fmt.Sprintf("acl.rules[%d]", i)
+
+		switch rule.Action {
+		case latest.ACLActionAllow, latest.ACLActionDeny:
+		default:
+			v.addf(field+".action", "must be %q or %q", latest.ACLActionAllow, latest.ACLActionDeny)
+		}
+
+		for j, peer := range rule.Peers {
+			peerField := fmt.Sprintf("%s.peers[%d]", field, j)
+
+			pk, err := ParsePublicKey(peer)
+			isKey := err == nil
+			_, isName := peerNames[peer]
+
+			switch {
+			case peer == "":
+				v.addf(peerField, "must be a peer name or public key")
+			case isKey && isName:
+				v.addf(peerField, "ambiguous, %q is both a peer name and a public key", peer)
+			case isKey:
+				if _, ok := peerKeys[pk]; !ok {
+					v.addf(peerField, "unknown peer public key %q", peer)
+				}
+			case !isName:
+				v.addf(peerField, "unknown peer %q", peer)
+			}
+		}
+
+		switch rule.Protocol {
+		case "", "tcp", "udp":
+		case "icmp":
+			if len(rule.Ports) > 0 {
+				v.addf(field+".ports", "not supported for icmp")
+			}
+		default:
+			v.addf(field+".protocol", "must be one of \"tcp\", \"udp\" or \"icmp\"")
+		}
+
+		for j, ports := range rule.Ports {
+			if _, _, err := ParsePortRange(ports); err != nil {
+				v.add(fmt.Sprintf("%s.ports[%d]", field, j), err)
+			}
+		}
+	}
+}
+
+func validatePortForwards(v *validator, portForwards []latest.PortForwardConfig) {
+	names := make(map[string]string)
+
+	for i, portForward := range portForwards {
+		field := fmt.Sprintf("portForwards[%d]", i)
+
+		if portForward.Name == "" {
+			v.addf(field+".name", "is required")
+		} else if existing, ok := names[portForward.Name]; ok {
+			v.addf(field+".name", "duplicate name %q (also %s)", portForward.Name, existing)
+		} else {
+			names[portForward.Name] = field + ".name"
+		}
+
+		switch portForward.Direction {
+		case latest.PortForwardDirectionInbound, latest.PortForwardDirectionOutbound:
+		default:
+			v.addf(field+".direction", "must be %q or %q",
+				latest.PortForwardDirectionInbound, latest.PortForwardDirectionOutbound)
+		}
+
+		switch portForward.Protocol {
+		case "", "tcp", "udp":
+		default:
+			v.addf(field+".protocol", "must be \"tcp\" or \"udp\"")
+		}
+
+		if _, portStr, err := stdnet.SplitHostPort(portForward.ListenAddress); err != nil {
+			v.add(field+".listenAddress", fmt.Errorf("malformed address: %w", err))
+		} else if _, err := strconv.ParseUint(portStr, 10, 16); err != nil {
+			v.addf(field+".listenAddress", "port must be between 0 and 65535")
+		}
+
+		if err := validateEndpoint(portForward.TargetAddress); err != nil {
+			v.add(field+".targetAddress", err)
+		}
+
+		if portForward.UDPIdleTimeout != 0 {
+			if portForward.Protocol != "udp" {
+				v.addf(field+".udpIdleTimeout", "only supported for udp")
+			} else if portForward.UDPIdleTimeout < 0 {
+				v.addf(field+".udpIdleTimeout", "must be positive")
+			}
+		}
+	}
+}
+
+type validator struct {
+	result *multierror.Error
+}
+
+func (v *validator) add(field string, err error) {
+	v.result = multierror.Append(v.result, &FieldError{Field: field, Err: err})
+}
+
+func (v *validator) addf(field, format string, a ...any) {
+	v.add(field, fmt.Errorf(format, a...))
+}
+
+// ParsePublicKey parses a base64 encoded public key. Unlike
+// NoisePublicKey.FromString, the key must decode to exactly 32 bytes.
+func ParsePublicKey(s string) (types.NoisePublicKey, error) {
+	var pk types.NoisePublicKey
+	if err := parseKey(pk[:], s); err != nil {
+		return types.NoisePublicKey{}, err
+	}
+
+	return pk, nil
+}
+
+// parseKey decodes a base64 encoded key into dst, the key must be exactly
+// the length of dst.
+func parseKey(dst []byte, s string) error {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("malformed key: %w", err)
+	}
+
+	if len(b) != len(dst) {
+		return fmt.Errorf("malformed key: must be %d bytes, got %d", len(dst), len(b))
+	}
+
+	copy(dst, b)
+	return nil
+}
+
+func validateZone(zone string) error {
+	name := strings.TrimSuffix(zone, ".")
+	if name == "" {
+		// Every name would be a peer name, and nothing would be forwarded.
+		return errors.New("must not be the root zone")
+	}
+
+	if len(name) > maxDomainNameLength {
+		return errors.New("malformed domain name")
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > maxLabelLength {
+			return errors.New("malformed domain name")
+		}
+	}
+
+	return nil
+}
+
+func validateEndpoint(endpoint string) error {
+	host, portStr, err := stdnet.SplitHostPort(endpoint)
+	if err != nil {
+		return fmt.Errorf("malformed endpoint: %w", err)
+	}
+
+	if host == "" {
+		return errors.New("missing host")
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return errors.New("port must be between 1 and 65535")
+	}
+
+	return nil
+}
+
+// ParsePortRange parses either a single port (eg. "80") or an inclusive
+// range of ports (eg. "8000-8999").
+func ParsePortRange(s string) (uint16, uint16, error) {
+	startStr, endStr, isRange := strings.Cut(s, "-")
+
+	start, err := strconv.ParseUint(startStr, 10, 16)
+	if err != nil || start == 0 {
+		return 0, 0, fmt.Errorf("malformed port %q", startStr)
+	}
+
+	if !isRange {
+		return uint16(start), uint16(start), nil
+	}
+
+	end, err := strconv.ParseUint(endStr, 10, 16)
+	if err != nil || end == 0 {
+		return 0, 0, fmt.Errorf("malformed port %q", endStr)
+	}
+
+	if end < start {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+
+	return uint16(start), uint16(end), nil
+}
+
+// ParsePrefix parses either a CIDR prefix or a single IP address (which is
+// treated as a host prefix).
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -12,41 +12,96 @@ package noisysockets
 import (
 	"fmt"
 	"net/netip"
+	"slices"
+	"sync"
 
+	"github.com/noisysockets/noisysockets/internal/allowedips"
 	"github.com/noisysockets/noisysockets/types"
 )
 
+// peerDirectory maps peer names and addresses to public keys.
+// It is safe for concurrent use, as it is read on the packet hot path
+// while peers are being added and removed.
 type peerDirectory struct {
-	peerNames       map[string]types.NoisePublicKey
-	peerAddresses   map[types.NoisePublicKey][]netip.Addr
-	fromPeerAddress map[netip.Addr]types.NoisePublicKey
+	mu             sync.RWMutex
+	peerNames      map[string]types.NoisePublicKey
+	peerNamesByKey map[types.NoisePublicKey]string
+	// peerAddresses contains the host addresses of each peer.
+	peerAddresses map[types.NoisePublicKey][]netip.Addr
+	// allowedIPs is used to route packets by longest prefix match.
+	allowedIPs allowedips.Table
 }
 
 func newPeerDirectory() *peerDirectory {
 	return &peerDirectory{
-		peerNames:       make(map[string]types.NoisePublicKey),
-		peerAddresses:   make(map[types.NoisePublicKey][]netip.Addr),
-		fromPeerAddress: make(map[netip.Addr]types.NoisePublicKey),
+		peerNames:      make(map[string]types.NoisePublicKey),
+		peerNamesByKey: make(map[types.NoisePublicKey]string),
+		peerAddresses:  make(map[types.NoisePublicKey][]netip.Addr),
 	}
 }
 
-func (pd *peerDirectory) AddPeer(name string, publicKey types.NoisePublicKey, addrs []netip.Addr) error {
-	if name != "" {
-		pd.peerNames[name] = publicKey
+// AddPeer adds a peer to the directory, prefixes are the peers allowed IPs.
+// Single IP prefixes are treated as host addresses of the peer.
+func (pd *peerDirectory) AddPeer(name string, publicKey types.NoisePublicKey, prefixes []netip.Prefix) error {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
+	if _, ok := pd.peerAddresses[publicKey]; ok {
+		return fmt.Errorf("peer %s already exists", publicKey)
 	}
-	pd.peerAddresses[publicKey] = addrs
-	for _, addr := range addrs {
-		if _, ok := pd.fromPeerAddress[addr]; ok {
-			return fmt.Errorf("address %s already in use", addr)
-		}
 
-		pd.fromPeerAddress[addr] = publicKey
+	if err := pd.checkConflictsLocked(name, prefixes, publicKey); err != nil {
+		return err
+	}
+
+	pd.addPeerLocked(name, publicKey, prefixes)
+
+	return nil
+}
+
+// UpdatePeer replaces the name and allowed IPs of an existing peer.
+func (pd *peerDirectory) UpdatePeer(name string, publicKey types.NoisePublicKey, prefixes []netip.Prefix) error {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
+	if _, ok := pd.peerAddresses[publicKey]; !ok {
+		return fmt.Errorf("peer %s does not exist", publicKey)
 	}
 
+	if err := pd.checkConflictsLocked(name, prefixes, publicKey); err != nil {
+		return err
+	}
+
+	pd.removePeerLocked(publicKey)
+	pd.addPeerLocked(name, publicKey, prefixes)
+
 	return nil
 }
 
+// Replace replaces the contents of the directory with those of next, in a
+// single step. As next was populated using AddPeer, it is free of conflicts.
+// next must not be used afterwards.
+func (pd *peerDirectory) Replace(next *peerDirectory) {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
+	pd.peerNames = next.peerNames
+	pd.peerNamesByKey = next.peerNamesByKey
+	pd.peerAddresses = next.peerAddresses
+	pd.allowedIPs = next.allowedIPs
+}
+
+func (pd *peerDirectory) RemovePeer(publicKey types.NoisePublicKey) {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
+	pd.removePeerLocked(publicKey)
+}
+
 func (pd *peerDirectory) LookupPeerAddressesByName(name string) ([]netip.Addr, bool) {
+	pd.mu.RLock()
+	defer pd.mu.RUnlock()
+
 	publicKey, ok := pd.peerNames[name]
 	if !ok {
 		return nil, false
@@ -55,7 +110,108 @@ func (pd *peerDirectory) LookupPeerAddressesByName(name string) ([]netip.Addr, b
 	return addrs, ok
 }
 
+func (pd *peerDirectory) LookupPeerNameByPublicKey(publicKey types.NoisePublicKey) (string, bool) {
+	pd.mu.RLock()
+	defer pd.mu.RUnlock()
+
+	name, ok := pd.peerNamesByKey[publicKey]
+	return name, ok
+}
+
+// LookupPeerNameByAddress returns the name of the peer with the given host
+// address, addresses that are only routed to a peer (eg. a gateway) are not
+// matched.
+func (pd *peerDirectory) LookupPeerNameByAddress(addr netip.Addr) (string, bool) {
+	pd.mu.RLock()
+	defer pd.mu.RUnlock()
+
+	publicKey, ok := pd.lookupPeerByHostAddressLocked(addr)
+	if !ok {
+		return "", false
+	}
+
+	name, ok := pd.peerNamesByKey[publicKey]
+	return name, ok
+}
+
+// LookupPeerByHostAddress returns the peer with the given host address, as
+// with LookupPeerNameByAddress routed addresses are not matched.
+func (pd *peerDirectory) LookupPeerByHostAddress(addr netip.Addr) (types.NoisePublicKey, bool) {
+	pd.mu.RLock()
+	defer pd.mu.RUnlock()
+
+	return pd.lookupPeerByHostAddressLocked(addr)
+}
+
+func (pd *peerDirectory) lookupPeerByHostAddressLocked(addr netip.Addr) (types.NoisePublicKey, bool) {
+	publicKey, ok := pd.allowedIPs.Lookup(addr)
+	if !ok || !slices.Contains(pd.peerAddresses[publicKey], addr) {
+		return types.NoisePublicKey{}, false
+	}
+
+	return publicKey, true
+}
+
+// LookupPeerByAddress returns the peer with the longest allowed IP prefix
+// containing the given address.
 func (pd *peerDirectory) LookupPeerByAddress(addr netip.Addr) (types.NoisePublicKey, bool) {
-	publicKey, ok := pd.fromPeerAddress[addr]
-	return publicKey, ok
+	pd.mu.RLock()
+	defer pd.mu.RUnlock()
+
+	return pd.allowedIPs.Lookup(addr)
+}
+
+// Prefixes returns the allowed IP prefixes of all peers, ordered from most
+// to least specific.
+func (pd *peerDirectory) Prefixes() []netip.Prefix {
+	pd.mu.RLock()
+	defer pd.mu.RUnlock()
+
+	return pd.allowedIPs.Prefixes()
+}
+
+// checkConflictsLocked checks that the name and prefixes are not in use by
+// any peer other than the given owners.
+func (pd *peerDirectory) checkConflictsLocked(name string, prefixes []netip.Prefix, owners ...types.NoisePublicKey) error {
+	if name != "" {
+		if existing, ok := pd.peerNames[name]; ok && !slices.Contains(owners, existing) {
+			return fmt.Errorf("name %q already in use", name)
+		}
+	}
+
+	for _, prefix := range prefixes {
+		if existing, ok := pd.allowedIPs.Get(prefix); ok && !slices.Contains(owners, existing) {
+			if prefix.IsSingleIP() {
+				return fmt.Errorf("address %s already in use", prefix.Addr())
+			}
+			return fmt.Errorf("prefix %s already in use", prefix)
+		}
+	}
+
+	return nil
+}
+
+func (pd *peerDirectory) addPeerLocked(name string, publicKey types.NoisePublicKey, prefixes []netip.Prefix) {
+	if name != "" {
+		pd.peerNames[name] = publicKey
+		pd.peerNamesByKey[publicKey] = name
+	}
+
+	addrs := []netip.Addr{}
+	for _, prefix := range prefixes {
+		if prefix.IsSingleIP() {
+			addrs = append(addrs, prefix.Addr())
+		}
+		pd.allowedIPs.Insert(prefix, publicKey)
+	}
+	pd.peerAddresses[publicKey] = addrs
+}
+
+func (pd *peerDirectory) removePeerLocked(publicKey types.NoisePublicKey) {
+	if name, ok := pd.peerNamesByKey[publicKey]; ok {
+		delete(pd.peerNames, name)
+		delete(pd.peerNamesByKey, publicKey)
+	}
+	pd.allowedIPs.RemoveByPeer(publicKey)
+	delete(pd.peerAddresses, publicKey)
 }
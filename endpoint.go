@@ -0,0 +1,226 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package noisysockets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	stdnet "net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/noisysockets/noisysockets/internal/conn"
+	"github.com/noisysockets/noisysockets/internal/dns"
+	"github.com/noisysockets/noisysockets/internal/transport"
+	"github.com/noisysockets/noisysockets/network"
+)
+
+const (
+	// defaultEndpointRefreshInterval is how often endpoint hostnames are
+	// re-resolved when the TTL of the DNS records is unknown.
+	defaultEndpointRefreshInterval = 5 * time.Minute
+	// minEndpointRefreshInterval avoids hammering DNS servers with very low TTLs.
+	minEndpointRefreshInterval = 30 * time.Second
+	// maxEndpointRefreshInterval bounds how long a stale address can be used.
+	maxEndpointRefreshInterval = time.Hour
+	// endpointLookupTimeout is the timeout for resolving an endpoint hostname.
+	endpointLookupTimeout = 30 * time.Second
+)
+
+// peerEndpoint is the configured endpoint of a peer.
+type peerEndpoint struct {
+	// endpoint is the endpoint as configured, eg. "gateway.example.com:51820".
+	endpoint string
+	host     string
+	port     uint16
+	// addrs are the resolved addresses of the endpoint.
+	addrs []netip.AddrPort
+}
+
+// resolvePeerEndpoint parses and resolves the configured endpoint of a peer.
+func resolvePeerEndpoint(ctx context.Context, endpoint string) (*peerEndpoint, error) {
+	host, portStr, err := stdnet.SplitHostPort(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse peer endpoint: %w", err)
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse peer port: %w", err)
+	}
+
+	ep := &peerEndpoint{
+		endpoint: endpoint,
+		host:     host,
+		port:     uint16(port),
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		ep.addrs = []netip.AddrPort{netip.AddrPortFrom(addr, ep.port)}
+		return ep, nil
+	}
+
+	addrs, err := stdnet.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve peer address: %w", err)
+	}
+
+	ep.setAddrs(addrs)
+
+	return ep, nil
+}
+
+// isHostname returns true if the endpoint needs to be periodically re-resolved.
+func (ep *peerEndpoint) isHostname() bool {
+	_, err := netip.ParseAddr(ep.host)
+	return err != nil
+}
+
+func (ep *peerEndpoint) setAddrs(addrs []netip.Addr) {
+	ep.addrs = make([]netip.AddrPort, 0, len(addrs))
+	for _, addr := range addrs {
+		ep.addrs = append(ep.addrs, netip.AddrPortFrom(addr.Unmap(), ep.port))
+	}
+}
+
+func (ep *peerEndpoint) candidates() []conn.Endpoint {
+	candidates := make([]conn.Endpoint, 0, len(ep.addrs))
+	for _, addr := range ep.addrs {
+		candidates = append(candidates, &conn.StdNetEndpoint{AddrPort: addr})
+	}
+	return candidates
+}
+
+// endpointResolver periodically re-resolves the hostname of a peer's
+// configured endpoint, respecting the TTL of its DNS records.
+type endpointResolver struct {
+	logger   *slog.Logger
+	peer     *transport.Peer
+	endpoint *peerEndpoint
+	// resolver queries the system DNS servers directly, as the system resolver
+	// doesn't expose TTLs. It is nil if the system DNS servers are unknown.
+	resolver *dns.Resolver
+	cancel   context.CancelFunc
+}
+
+func startEndpointResolver(logger *slog.Logger, peer *transport.Peer, endpoint *peerEndpoint) *endpointResolver {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r := &endpointResolver{
+		logger:   logger,
+		peer:     peer,
+		endpoint: endpoint,
+		cancel:   cancel,
+	}
+
+	if dnsServers, err := dns.SystemDNSServers(); err == nil && len(dnsServers) > 0 {
+		// Bypass the cache, as we want the TTL of the records as they are now.
+		r.resolver = dns.NewResolver(network.Host(), nil, dns.ResolverConfig{
+			Servers: dnsServers,
+		})
+	} else {
+		logger.Debug("System DNS servers unknown, endpoint TTLs will not be respected",
+			"peer", peer, "error", err)
+	}
+
+	go r.run(ctx)
+
+	return r
+}
+
+// Stop stops re-resolving the endpoint.
+func (r *endpointResolver) Stop() {
+	r.cancel()
+}
+
+func (r *endpointResolver) run(ctx context.Context) {
+	// Resolve immediately so that we learn the TTL of the records.
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		interval, err := r.refresh(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			r.logger.Warn("Failed to resolve peer endpoint",
+				"peer", r.peer, "endpoint", r.endpoint.endpoint, "error", err)
+
+			interval = minEndpointRefreshInterval
+		}
+
+		timer.Reset(interval)
+	}
+}
+
+func (r *endpointResolver) refresh(ctx context.Context) (time.Duration, error) {
+	lookupCtx, cancel := context.WithTimeout(ctx, endpointLookupTimeout)
+	defer cancel()
+
+	addrs, interval, err := r.lookup(lookupCtx)
+	if err != nil {
+		return 0, err
+	}
+
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	resolved := peerEndpoint{
+		endpoint: r.endpoint.endpoint,
+		host:     r.endpoint.host,
+		port:     r.endpoint.port,
+	}
+	resolved.setAddrs(addrs)
+
+	r.peer.SetEndpointCandidates(resolved.candidates())
+
+	return interval, nil
+}
+
+// lookup resolves the endpoint hostname, returning its addresses and how long
+// until it should be resolved again.
+func (r *endpointResolver) lookup(ctx context.Context) ([]netip.Addr, time.Duration, error) {
+	if r.resolver != nil {
+		addrs, ttl, err := r.resolver.LookupHostWithTTL(ctx, r.endpoint.host)
+		if err == nil {
+			return addrs, min(max(ttl, minEndpointRefreshInterval), maxEndpointRefreshInterval), nil
+		}
+
+		// The host may only be known to the system resolver (eg. from the hosts
+		// file), otherwise try again later.
+		var dnsErr *stdnet.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			return nil, 0, err
+		}
+	}
+
+	addrs, err := stdnet.DefaultResolver.LookupNetIP(ctx, "ip", r.endpoint.host)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The DNS servers don't know about the host, so later refreshes go
+	// straight to the system resolver.
+	r.resolver = nil
+
+	return addrs, defaultEndpointRefreshInterval, nil
+}
@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package noisysockets
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/noisysockets/noisysockets/internal/transport"
+	"github.com/noisysockets/noisysockets/types"
+)
+
+// EventType is the type of a network event.
+type EventType int
+
+const (
+	// EventEndpointChanged is emitted when the endpoint of a peer is changed
+	// locally, eg. because its hostname resolved to a new address.
+	EventEndpointChanged EventType = iota
+	// EventPeerAdded is emitted when a peer is added to the network.
+	EventPeerAdded
+	// EventPeerRemoved is emitted when a peer is removed from the network.
+	EventPeerRemoved
+	// EventPeerRoamed is emitted when a peer starts sending from a new endpoint.
+	EventPeerRoamed
+	// EventHandshakeCompleted is emitted when a handshake with a peer completes
+	// and a new session keypair is in use.
+	EventHandshakeCompleted
+	// EventHandshakeFailed is emitted when a handshake with a peer is given up
+	// on after too many attempts. Further handshakes will only be attempted
+	// when there is traffic for the peer.
+	EventHandshakeFailed
+	// EventKeypairExpired is emitted when the session keys for a peer have
+	// been discarded because no new handshake completed in time.
+	EventKeypairExpired
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventEndpointChanged:
+		return "EndpointChanged"
+	case EventPeerAdded:
+		return "PeerAdded"
+	case EventPeerRemoved:
+		return "PeerRemoved"
+	case EventPeerRoamed:
+		return "PeerRoamed"
+	case EventHandshakeCompleted:
+		return "HandshakeCompleted"
+	case EventHandshakeFailed:
+		return "HandshakeFailed"
+	case EventKeypairExpired:
+		return "KeypairExpired"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+// Event is a notable change in the state of a peer.
+type Event struct {
+	// Type is the type of event.
+	Type EventType
+	// PublicKey is the public key of the peer.
+	PublicKey types.NoisePublicKey
+	// Endpoint is the new endpoint of the peer (for EventEndpointChanged and
+	// EventPeerRoamed).
+	Endpoint netip.AddrPort
+	// Time is when the event occurred.
+	Time time.Time
+}
+
+type eventSubscribers struct {
+	mu     sync.RWMutex
+	nextID uint64
+	chans  map[uint64]chan<- Event
+}
+
+// Subscribe registers a channel to receive network events. Events are sent
+// without blocking, so if the channel is not ready (eg. its buffer is full)
+// the event is dropped. Call the returned function to unsubscribe.
+func (net *NoisySocketsNetwork) Subscribe(ch chan<- Event) (unsubscribe func()) {
+	net.subscribers.mu.Lock()
+	defer net.subscribers.mu.Unlock()
+
+	if net.subscribers.chans == nil {
+		net.subscribers.chans = make(map[uint64]chan<- Event)
+	}
+
+	id := net.subscribers.nextID
+	net.subscribers.nextID++
+	net.subscribers.chans[id] = ch
+
+	return func() {
+		net.subscribers.mu.Lock()
+		defer net.subscribers.mu.Unlock()
+
+		delete(net.subscribers.chans, id)
+	}
+}
+
+func (net *NoisySocketsNetwork) publishEvent(event Event) {
+	net.subscribers.mu.RLock()
+	defer net.subscribers.mu.RUnlock()
+
+	for _, ch := range net.subscribers.chans {
+		select {
+		case ch <- event:
+		default:
+			net.logger.Debug("Dropping event for slow subscriber", "type", event.Type)
+		}
+	}
+}
+
+func (net *NoisySocketsNetwork) handleTransportEvent(transportEvent transport.Event) {
+	event := Event{
+		PublicKey: transportEvent.PublicKey,
+		Time:      transportEvent.Time,
+	}
+
+	switch transportEvent.Type {
+	case transport.EventEndpointChanged:
+		event.Type = EventEndpointChanged
+	case transport.EventRoamed:
+		event.Type = EventPeerRoamed
+	case transport.EventHandshakeCompleted:
+		event.Type = EventHandshakeCompleted
+	case transport.EventHandshakeFailed:
+		event.Type = EventHandshakeFailed
+	case transport.EventKeypairExpired:
+		event.Type = EventKeypairExpired
+	default:
+		return
+	}
+
+	if transportEvent.Endpoint != nil {
+		event.Endpoint, _ = netip.ParseAddrPort(transportEvent.Endpoint.DstToString())
+	}
+
+	net.publishEvent(event)
+}
@@ -0,0 +1,291 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package forwarder forwards TCP connections and UDP datagrams received on
+// one network to an address on another network, eg. from the noisy network
+// to a service on the host.
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	stdnet "net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/noisysockets/noisysockets/network"
+)
+
+const (
+	// DefaultUDPIdleTimeout is how long a UDP session is kept open without
+	// any traffic, if not otherwise specified.
+	DefaultUDPIdleTimeout = 2 * time.Minute
+	// dialTimeout bounds how long connecting to the target may take.
+	dialTimeout = 30 * time.Second
+)
+
+// ErrForwarderClosed is returned by Shutdown if the forwarder has already
+// been closed.
+var ErrForwarderClosed = errors.New("forwarder closed")
+
+// Config is the configuration for a forwarder.
+type Config struct {
+	// Protocol is the protocol to forward, either "tcp" or "udp".
+	Protocol string
+	// ListenNetwork is the network on which to listen.
+	ListenNetwork network.Network
+	// ListenAddress is the local address on which to listen, eg. ":8080".
+	ListenAddress string
+	// TargetNetwork is the network over which to connect to the target.
+	TargetNetwork network.Network
+	// TargetAddress is the address to which to forward, eg. "localhost:80".
+	TargetAddress string
+	// UDPIdleTimeout is how long a UDP session is kept open without any
+	// traffic. If not specified, DefaultUDPIdleTimeout is used.
+	UDPIdleTimeout time.Duration
+}
+
+// Stats contains runtime statistics for a forwarder.
+type Stats struct {
+	// BytesSent is the number of bytes forwarded to the target.
+	BytesSent uint64
+	// BytesReceived is the number of bytes received from the target.
+	BytesReceived uint64
+	// ActiveSessions is the number of open TCP connections or UDP sessions.
+	ActiveSessions uint64
+	// TotalSessions is the number of TCP connections or UDP sessions that
+	// have been forwarded.
+	TotalSessions uint64
+}
+
+// Forwarder forwards connections (or datagrams) from a listening address
+// on one network to a target address on another.
+type Forwarder struct {
+	logger *slog.Logger
+	conf   Config
+	lis    stdnet.Listener
+	pc     stdnet.PacketConn
+
+	bytesSent      atomic.Uint64
+	bytesReceived  atomic.Uint64
+	activeSessions atomic.Int64
+	totalSessions  atomic.Uint64
+
+	mu           sync.Mutex
+	shuttingDown bool
+	closed       bool
+	sessions     map[io.Closer]struct{}
+	// udpSessions are the UDP sessions, by client address.
+	udpSessions map[string]*udpSession
+	// sessionsDone is closed when the last session ends during shutdown.
+	sessionsDone chan struct{}
+	// ctx is canceled when the forwarder is closed, aborting any dials.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a forwarder, it listens immediately and forwards in the
+// background until closed.
+func New(logger *slog.Logger, conf Config) (*Forwarder, error) {
+	if conf.UDPIdleTimeout == 0 {
+		conf.UDPIdleTimeout = DefaultUDPIdleTimeout
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	f := &Forwarder{
+		logger: logger.With(
+			slog.String("protocol", conf.Protocol),
+			slog.String("listen", conf.ListenAddress),
+			slog.String("target", conf.TargetAddress)),
+		conf:         conf,
+		sessions:     make(map[io.Closer]struct{}),
+		udpSessions:  make(map[string]*udpSession),
+		sessionsDone: make(chan struct{}),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+
+	switch conf.Protocol {
+	case "tcp":
+		lis, err := conf.ListenNetwork.Listen("tcp", conf.ListenAddress)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to listen: %w", err)
+		}
+		f.lis = lis
+
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.serveTCP()
+		}()
+	case "udp":
+		pc, err := conf.ListenNetwork.ListenPacket("udp", conf.ListenAddress)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to listen: %w", err)
+		}
+		f.pc = pc
+
+		f.wg.Add(2)
+		go func() {
+			defer f.wg.Done()
+			f.serveUDP()
+		}()
+		go func() {
+			defer f.wg.Done()
+			f.expireUDPSessions()
+		}()
+	default:
+		cancel()
+		return nil, fmt.Errorf("unsupported protocol: %s", conf.Protocol)
+	}
+
+	return f, nil
+}
+
+// Addr returns the address the forwarder is listening on.
+func (f *Forwarder) Addr() stdnet.Addr {
+	if f.lis != nil {
+		return f.lis.Addr()
+	}
+	return f.pc.LocalAddr()
+}
+
+// Stats returns runtime statistics for the forwarder.
+func (f *Forwarder) Stats() Stats {
+	return Stats{
+		BytesSent:      f.bytesSent.Load(),
+		BytesReceived:  f.bytesReceived.Load(),
+		ActiveSessions: uint64(f.activeSessions.Load()),
+		TotalSessions:  f.totalSessions.Load(),
+	}
+}
+
+// Shutdown gracefully shuts down the forwarder. New connections (or UDP
+// sessions) are no longer accepted and Shutdown waits for the active ones to
+// end. If the context expires first, the remaining sessions are closed and
+// the context's error is returned.
+func (f *Forwarder) Shutdown(ctx context.Context) error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return ErrForwarderClosed
+	}
+	if !f.shuttingDown {
+		f.shuttingDown = true
+		if len(f.sessions) == 0 {
+			close(f.sessionsDone)
+		}
+	}
+	f.mu.Unlock()
+
+	// UDP sessions share the listening socket, so it can only be closed once
+	// they have ended.
+	if f.lis != nil {
+		_ = f.lis.Close()
+	}
+
+	var err error
+	select {
+	case <-f.sessionsDone:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	_ = f.Close()
+
+	return err
+}
+
+// Close immediately closes the forwarder and all active sessions.
+func (f *Forwarder) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	for session := range f.sessions {
+		_ = session.Close()
+	}
+	f.mu.Unlock()
+
+	f.cancel()
+
+	if f.lis != nil {
+		_ = f.lis.Close()
+	}
+	if f.pc != nil {
+		_ = f.pc.Close()
+	}
+
+	f.wg.Wait()
+
+	return nil
+}
+
+// startSession registers a new session, it returns false if the forwarder
+// is shutting down.
+func (f *Forwarder) startSession(session io.Closer) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.startSessionLocked(session)
+}
+
+func (f *Forwarder) startSessionLocked(session io.Closer) bool {
+	if f.shuttingDown || f.closed {
+		return false
+	}
+
+	f.sessions[session] = struct{}{}
+	f.activeSessions.Add(1)
+	f.totalSessions.Add(1)
+
+	return true
+}
+
+func (f *Forwarder) endSession(session io.Closer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.sessions[session]; !ok {
+		return
+	}
+
+	delete(f.sessions, session)
+	f.activeSessions.Add(-1)
+
+	if session, ok := session.(*udpSession); ok {
+		delete(f.udpSessions, session.client.String())
+	}
+
+	if f.shuttingDown && len(f.sessions) == 0 {
+		close(f.sessionsDone)
+	}
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Uint64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n.Add(uint64(n))
+	return n, err
+}
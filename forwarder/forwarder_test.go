@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package forwarder_test
+
+import (
+	"context"
+	"io"
+	stdnet "net"
+	"testing"
+	"time"
+
+	"github.com/neilotoole/slogt"
+	"github.com/noisysockets/noisysockets/forwarder"
+	"github.com/noisysockets/noisysockets/network"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwarder(t *testing.T) {
+	logger := slogt.New(t)
+
+	t.Run("TCP", func(t *testing.T) {
+		lis, err := stdnet.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = lis.Close()
+		})
+
+		// A TCP echo server.
+		go func() {
+			for {
+				conn, err := lis.Accept()
+				if err != nil {
+					return
+				}
+
+				go func() {
+					defer conn.Close()
+					_, _ = io.Copy(conn, conn)
+				}()
+			}
+		}()
+
+		fwd, err := forwarder.New(logger, forwarder.Config{
+			Protocol:      "tcp",
+			ListenNetwork: network.Host(),
+			ListenAddress: "127.0.0.1:0",
+			TargetNetwork: network.Host(),
+			TargetAddress: lis.Addr().String(),
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, fwd.Close())
+		})
+
+		conn, err := stdnet.Dial("tcp", fwd.Addr().String())
+		require.NoError(t, err)
+
+		_, err = conn.Write([]byte("Hello, world!"))
+		require.NoError(t, err)
+
+		buf := make([]byte, 13)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		require.Equal(t, "Hello, world!", string(buf))
+
+		stats := fwd.Stats()
+		require.Equal(t, uint64(13), stats.BytesSent)
+		require.Equal(t, uint64(13), stats.BytesReceived)
+		require.Equal(t, uint64(1), stats.ActiveSessions)
+		require.Equal(t, uint64(1), stats.TotalSessions)
+
+		shutdownErr := make(chan error, 1)
+		go func() {
+			shutdownErr <- fwd.Shutdown(context.Background())
+		}()
+
+		// New connections should be refused, while the existing connection
+		// is still served.
+		require.Eventually(t, func() bool {
+			conn, err := stdnet.Dial("tcp", fwd.Addr().String())
+			if err == nil {
+				_ = conn.Close()
+			}
+			return err != nil
+		}, 5*time.Second, 10*time.Millisecond)
+
+		_, err = conn.Write([]byte("Goodbye!"))
+		require.NoError(t, err)
+
+		buf = make([]byte, 8)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		require.Equal(t, "Goodbye!", string(buf))
+
+		require.NoError(t, conn.Close())
+
+		select {
+		case err := <-shutdownErr:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for shutdown")
+		}
+	})
+
+	t.Run("UDP", func(t *testing.T) {
+		pc, err := stdnet.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = pc.Close()
+		})
+
+		// A UDP echo server.
+		go func() {
+			buf := make([]byte, 1500)
+			for {
+				n, addr, err := pc.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+
+				if _, err := pc.WriteTo(buf[:n], addr); err != nil {
+					return
+				}
+			}
+		}()
+
+		fwd, err := forwarder.New(logger, forwarder.Config{
+			Protocol:       "udp",
+			ListenNetwork:  network.Host(),
+			ListenAddress:  "127.0.0.1:0",
+			TargetNetwork:  network.Host(),
+			TargetAddress:  pc.LocalAddr().String(),
+			UDPIdleTimeout: 100 * time.Millisecond,
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, fwd.Close())
+		})
+
+		conn, err := stdnet.Dial("udp", fwd.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+
+		_, err = conn.Write([]byte("Hello, world!"))
+		require.NoError(t, err)
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+		buf := make([]byte, 1500)
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, "Hello, world!", string(buf[:n]))
+
+		stats := fwd.Stats()
+		require.Equal(t, uint64(13), stats.BytesSent)
+		require.Equal(t, uint64(13), stats.BytesReceived)
+		require.Equal(t, uint64(1), stats.TotalSessions)
+
+		// The idle session should be closed.
+		require.Eventually(t, func() bool {
+			return fwd.Stats().ActiveSessions == 0
+		}, 5*time.Second, 10*time.Millisecond)
+
+		// And a new session created on the next datagram.
+		_, err = conn.Write([]byte("Hello again!"))
+		require.NoError(t, err)
+
+		n, err = conn.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, "Hello again!", string(buf[:n]))
+
+		require.Equal(t, uint64(2), fwd.Stats().TotalSessions)
+	})
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package forwarder
+
+import (
+	"context"
+	"io"
+	stdnet "net"
+	"sync"
+	"sync/atomic"
+)
+
+func (f *Forwarder) serveTCP() {
+	for {
+		conn, err := f.lis.Accept()
+		if err != nil {
+			f.mu.Lock()
+			stopping := f.shuttingDown || f.closed
+			f.mu.Unlock()
+
+			if !stopping {
+				f.logger.Warn("Failed to accept connection", "error", err)
+			}
+			return
+		}
+
+		if !f.startSession(conn) {
+			_ = conn.Close()
+			return
+		}
+
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			defer f.endSession(conn)
+			defer conn.Close()
+
+			f.forwardTCP(conn)
+		}()
+	}
+}
+
+func (f *Forwarder) forwardTCP(conn stdnet.Conn) {
+	ctx, cancel := context.WithTimeout(f.ctx, dialTimeout)
+	defer cancel()
+
+	target, err := f.conf.TargetNetwork.DialContext(ctx, "tcp", f.conf.TargetAddress)
+	if err != nil {
+		f.logger.Warn("Failed to connect to target",
+			"client", conn.RemoteAddr(), "error", err)
+		return
+	}
+	defer target.Close()
+
+	// Closing the client connection (eg. by Close) should also close the
+	// target connection.
+	stop := context.AfterFunc(f.ctx, func() {
+		_ = target.Close()
+	})
+	defer stop()
+
+	f.logger.Debug("Forwarding connection", "client", conn.RemoteAddr())
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		copyAndCloseWrite(target, conn, &f.bytesSent)
+	}()
+
+	go func() {
+		defer wg.Done()
+		copyAndCloseWrite(conn, target, &f.bytesReceived)
+	}()
+
+	wg.Wait()
+}
+
+// copyAndCloseWrite copies from src to dst, and then signals EOF to dst (or
+// closes it if half-close is not supported).
+func copyAndCloseWrite(dst, src stdnet.Conn, n *atomic.Uint64) {
+	_, _ = io.Copy(&countingWriter{w: dst, n: n}, src)
+
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		if err := cw.CloseWrite(); err == nil {
+			return
+		}
+	}
+
+	_ = dst.Close()
+}
@@ -0,0 +1,220 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package forwarder
+
+import (
+	"context"
+	"errors"
+	stdnet "net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxDatagramSize is the largest UDP payload we will forward.
+	maxDatagramSize = 65535
+	// maxUDPSessions bounds the number of concurrent UDP sessions, as each
+	// one holds a socket to the target.
+	maxUDPSessions = 1024
+	// udpSessionQueueSize is the number of datagrams queued for a session
+	// while the target is dialed (or is slow), further datagrams are dropped.
+	udpSessionQueueSize = 64
+)
+
+var errTooManyUDPSessions = errors.New("too many UDP sessions")
+
+// udpSession is a pseudo-connection between a client and the target, the
+// target sees each client as a distinct source port.
+type udpSession struct {
+	client stdnet.Addr
+	// queue holds datagrams from the client until they are sent to the target.
+	queue chan []byte
+	// lastActive is the time of the last datagram, in unix nanoseconds.
+	lastActive atomic.Int64
+	// ctx is canceled when the session is closed.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (s *udpSession) Close() error {
+	s.cancel()
+	return nil
+}
+
+func (s *udpSession) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+func (f *Forwarder) serveUDP() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := f.pc.ReadFrom(buf)
+		if err != nil {
+			f.mu.Lock()
+			closed := f.closed
+			f.mu.Unlock()
+
+			if !closed {
+				f.logger.Warn("Failed to read datagram", "error", err)
+			}
+			return
+		}
+
+		f.mu.Lock()
+		session, ok := f.udpSessions[addr.String()]
+		f.mu.Unlock()
+
+		if !ok {
+			session, err = f.newUDPSession(addr)
+			if err != nil {
+				f.logger.Warn("Failed to create UDP session",
+					"client", addr, "error", err)
+				continue
+			}
+
+			// The forwarder is shutting down.
+			if session == nil {
+				continue
+			}
+		}
+
+		session.touch()
+
+		select {
+		case session.queue <- append([]byte(nil), buf[:n]...):
+		default:
+			f.logger.Debug("Dropping datagram, session queue is full", "client", addr)
+		}
+	}
+}
+
+// newUDPSession registers a session for a new client. The target is dialed
+// in the background, so that a slow dial doesn't hold up other clients.
+func (f *Forwarder) newUDPSession(client stdnet.Addr) (*udpSession, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.udpSessions) >= maxUDPSessions {
+		return nil, errTooManyUDPSessions
+	}
+
+	ctx, cancel := context.WithCancel(f.ctx)
+
+	session := &udpSession{
+		client: client,
+		queue:  make(chan []byte, udpSessionQueueSize),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	session.touch()
+
+	if !f.startSessionLocked(session) {
+		cancel()
+		return nil, nil
+	}
+
+	f.udpSessions[client.String()] = session
+
+	f.logger.Debug("Forwarding UDP session", "client", client)
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		defer f.endSession(session)
+		defer cancel()
+
+		f.runUDPSession(session)
+	}()
+
+	return session, nil
+}
+
+func (f *Forwarder) runUDPSession(session *udpSession) {
+	dialCtx, cancel := context.WithTimeout(session.ctx, dialTimeout)
+	target, err := f.conf.TargetNetwork.DialContext(dialCtx, "udp", f.conf.TargetAddress)
+	cancel()
+	if err != nil {
+		if session.ctx.Err() == nil {
+			f.logger.Warn("Failed to dial target", "client", session.client, "error", err)
+		}
+		return
+	}
+	defer target.Close()
+
+	// Unblock reads from the target once the session is closed.
+	stop := context.AfterFunc(session.ctx, func() {
+		_ = target.Close()
+	})
+	defer stop()
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+
+		for {
+			select {
+			case <-session.ctx.Done():
+				return
+			case datagram := <-session.queue:
+				if _, err := target.Write(datagram); err != nil {
+					f.logger.Debug("Failed to forward datagram",
+						"client", session.client, "error", err)
+					continue
+				}
+
+				f.bytesSent.Add(uint64(len(datagram)))
+			}
+		}
+	}()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, err := target.Read(buf)
+		if err != nil {
+			return
+		}
+
+		session.touch()
+
+		if _, err := f.pc.WriteTo(buf[:n], session.client); err != nil {
+			f.logger.Debug("Failed to return datagram",
+				"client", session.client, "error", err)
+			continue
+		}
+
+		f.bytesReceived.Add(uint64(n))
+	}
+}
+
+// expireUDPSessions closes sessions that have been idle for longer than the
+// idle timeout.
+func (f *Forwarder) expireUDPSessions() {
+	ticker := time.NewTicker(max(f.conf.UDPIdleTimeout/4, 10*time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		idleSince := time.Now().Add(-f.conf.UDPIdleTimeout).UnixNano()
+
+		f.mu.Lock()
+		for _, session := range f.udpSessions {
+			if session.lastActive.Load() < idleSince {
+				f.logger.Debug("Closing idle UDP session", "client", session.client)
+				_ = session.Close()
+			}
+		}
+		f.mu.Unlock()
+	}
+}
@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package forwarder
+
+import (
+	stdnet "net"
+	"testing"
+	"time"
+
+	"github.com/neilotoole/slogt"
+	"github.com/noisysockets/noisysockets/network"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUDPSessionExpiry(t *testing.T) {
+	target, err := stdnet.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = target.Close()
+	})
+
+	f, err := New(slogt.New(t), Config{
+		Protocol:       "udp",
+		ListenNetwork:  network.Host(),
+		ListenAddress:  "127.0.0.1:0",
+		TargetNetwork:  network.Host(),
+		TargetAddress:  target.LocalAddr().String(),
+		UDPIdleTimeout: 500 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, f.Close())
+	})
+
+	udpSessions := func() int {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		return len(f.udpSessions)
+	}
+
+	// Each client gets its own session.
+	for i := 0; i < 3; i++ {
+		conn, err := stdnet.Dial("udp", f.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+
+		_, err = conn.Write([]byte("Hello, world!"))
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool {
+		return udpSessions() == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Idle sessions are forgotten.
+	require.Eventually(t, func() bool {
+		return udpSessions() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package grpcnet provides helpers for using gRPC over a noisy sockets network.
+package grpcnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	stdnet "net"
+
+	"github.com/noisysockets/noisysockets"
+	"github.com/noisysockets/noisysockets/types"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// AuthType is the authentication type reported by AuthInfo.
+const AuthType = "noisysockets"
+
+var (
+	// ErrUnknownPeer is returned when a connection is not from (or to) a host
+	// address of a known peer of a noisy sockets network.
+	ErrUnknownPeer = errors.New("connection is not from a known peer")
+	// ErrUnexpectedPeer is returned when a dialed connection is not to the
+	// expected peer.
+	ErrUnexpectedPeer = errors.New("connection is not to the expected peer")
+)
+
+// AuthInfo is the authentication information of a connection over a noisy
+// sockets network.
+type AuthInfo struct {
+	credentials.CommonAuthInfo
+	// Peer is the authenticated identity of the remote peer.
+	Peer noisysockets.PeerIdentity
+}
+
+// AuthType returns the type of info as a string.
+func (AuthInfo) AuthType() string {
+	return AuthType
+}
+
+// CredentialsOption configures transport credentials.
+type CredentialsOption func(*transportCredentials)
+
+// WithExpectedPeer requires dialed connections to be to the peer with the
+// given public key, eg. to guard against misconfigured peer names or routes.
+func WithExpectedPeer(pk types.NoisePublicKey) CredentialsOption {
+	return func(tc *transportCredentials) {
+		tc.expectedPeer = &pk
+	}
+}
+
+// NewCredentials returns transport credentials for connections dialed or
+// accepted on a noisy sockets network. Connections are already encrypted and
+// authenticated by the network, so no additional handshake is performed, but
+// the identity of the remote peer is made available as AuthInfo.
+func NewCredentials(opts ...CredentialsOption) credentials.TransportCredentials {
+	tc := &transportCredentials{}
+	for _, opt := range opts {
+		opt(tc)
+	}
+
+	return tc
+}
+
+type transportCredentials struct {
+	expectedPeer *types.NoisePublicKey
+}
+
+func (tc *transportCredentials) ClientHandshake(_ context.Context, _ string, conn stdnet.Conn) (stdnet.Conn, credentials.AuthInfo, error) {
+	authInfo, err := newAuthInfo(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if tc.expectedPeer != nil && !authInfo.Peer.PublicKey.Equals(*tc.expectedPeer) {
+		return nil, nil, fmt.Errorf("%w: got %s", ErrUnexpectedPeer, authInfo.Peer.PublicKey)
+	}
+
+	return conn, authInfo, nil
+}
+
+func (tc *transportCredentials) ServerHandshake(conn stdnet.Conn) (stdnet.Conn, credentials.AuthInfo, error) {
+	authInfo, err := newAuthInfo(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return conn, authInfo, nil
+}
+
+func (tc *transportCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: AuthType}
+}
+
+func (tc *transportCredentials) Clone() credentials.TransportCredentials {
+	clone := *tc
+	return &clone
+}
+
+func (tc *transportCredentials) OverrideServerName(string) error {
+	return nil
+}
+
+func newAuthInfo(conn stdnet.Conn) (AuthInfo, error) {
+	// Addresses that are only routed via a peer (eg. hosts behind a gateway)
+	// have no identity, traffic to them is only protected as far as the peer,
+	// so we can't claim privacy and integrity.
+	peer, ok := noisysockets.PeerFromConn(conn)
+	if !ok {
+		return AuthInfo{}, fmt.Errorf("%w: %s", ErrUnknownPeer, conn.RemoteAddr())
+	}
+
+	return AuthInfo{
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+		Peer:           *peer,
+	}, nil
+}
+
+// PeerFromContext returns the identity of the remote peer of a RPC, it can be
+// used by both server handlers and client interceptors.
+func PeerFromContext(ctx context.Context) (*noisysockets.PeerIdentity, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	authInfo, ok := p.AuthInfo.(AuthInfo)
+	if !ok {
+		return nil, false
+	}
+
+	return &authInfo.Peer, true
+}
@@ -0,0 +1,50 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package grpcnet
+
+import (
+	"context"
+	stdnet "net"
+
+	"github.com/noisysockets/noisysockets/network"
+	"google.golang.org/grpc"
+)
+
+// WithNetwork returns a DialOption that dials connections over the given
+// network. Targets can use the names of peers, eg. "passthrough:///server:50051".
+func WithNetwork(net network.Network) grpc.DialOption {
+	return grpc.WithContextDialer(func(ctx context.Context, addr string) (stdnet.Conn, error) {
+		return net.DialContext(ctx, "tcp", addr)
+	})
+}
+
+// DialOptions returns the options needed to dial a gRPC server over the
+// given network, using noisy sockets transport credentials.
+func DialOptions(net network.Network, opts ...CredentialsOption) []grpc.DialOption {
+	return []grpc.DialOption{
+		WithNetwork(net),
+		grpc.WithTransportCredentials(NewCredentials(opts...)),
+	}
+}
+
+// Listen returns a listener for a gRPC server on the given network address
+// (eg. ":50051"). The server should be created with ServerOptions() so that
+// the identity of peers is available to handlers.
+func Listen(net network.Network, address string) (stdnet.Listener, error) {
+	return net.Listen("tcp", address)
+}
+
+// ServerOptions returns the options needed to serve gRPC over a noisy
+// sockets network, using noisy sockets transport credentials.
+func ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.Creds(NewCredentials()),
+	}
+}
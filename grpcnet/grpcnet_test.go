@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package grpcnet_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/neilotoole/slogt"
+	"github.com/noisysockets/noisysockets"
+	"github.com/noisysockets/noisysockets/config/v1alpha1"
+	"github.com/noisysockets/noisysockets/grpcnet"
+	"github.com/noisysockets/noisysockets/types"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPC(t *testing.T) {
+	logger := slogt.New(t)
+
+	serverPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	clientPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	serverNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "server",
+		ListenPort: 12360,
+		PrivateKey: serverPrivateKey.String(),
+		IPs:        []string{"10.12.0.1", "10.12.1.1"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "client",
+				PublicKey: clientPrivateKey.PublicKey().String(),
+				IPs:       []string{"10.12.0.2"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, serverNet.Close())
+	})
+
+	clientNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "client",
+		ListenPort: 12361,
+		PrivateKey: clientPrivateKey.String(),
+		IPs:        []string{"10.12.0.2"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "server",
+				PublicKey: serverPrivateKey.PublicKey().String(),
+				Endpoint:  "localhost:12360",
+				// The server is also a gateway for a subnet.
+				IPs: []string{"10.12.0.1", "10.12.1.0/24"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, clientNet.Close())
+	})
+
+	lis, err := grpcnet.Listen(serverNet, ":50051")
+	require.NoError(t, err)
+
+	peers := make(chan *noisysockets.PeerIdentity, 1)
+	srv := grpc.NewServer(append(grpcnet.ServerOptions(),
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			peer, ok := grpcnet.PeerFromContext(ctx)
+			if ok {
+				peers <- peer
+			}
+			return handler(ctx, req)
+		}))...)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	t.Cleanup(srv.Stop)
+
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+
+	t.Run("Identity", func(t *testing.T) {
+		conn, err := grpc.Dial("server:50051",
+			grpcnet.DialOptions(clientNet, grpcnet.WithExpectedPeer(serverPrivateKey.PublicKey()))...)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, conn.Close())
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		t.Cleanup(cancel)
+
+		resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		require.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+		peer := <-peers
+		require.Equal(t, clientPrivateKey.PublicKey(), peer.PublicKey)
+		require.Equal(t, "client", peer.Name)
+	})
+
+	t.Run("Unexpected Peer", func(t *testing.T) {
+		conn, err := grpc.Dial("server:50051",
+			grpcnet.DialOptions(clientNet, grpcnet.WithExpectedPeer(clientPrivateKey.PublicKey()))...)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, conn.Close())
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		t.Cleanup(cancel)
+
+		_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		require.Error(t, err)
+		require.Equal(t, codes.Unavailable, status.Code(err))
+		require.Contains(t, err.Error(), grpcnet.ErrUnexpectedPeer.Error())
+	})
+
+	t.Run("Routed Address", func(t *testing.T) {
+		// The address is only routed via the server, so it could be any host
+		// behind it.
+		conn, err := grpc.Dial("10.12.1.1:50051", grpcnet.DialOptions(clientNet)...)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, conn.Close())
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		t.Cleanup(cancel)
+
+		_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		require.Error(t, err)
+		require.Equal(t, codes.Unavailable, status.Code(err))
+		require.Contains(t, err.Error(), grpcnet.ErrUnknownPeer.Error())
+	})
+}
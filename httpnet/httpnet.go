@@ -0,0 +1,49 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package httpnet provides HTTP clients, and a forward proxy, that send requests
+// over a network.Network.
+package httpnet
+
+import (
+	stdhttp "net/http"
+	"time"
+
+	"github.com/noisysockets/noisysockets/network"
+)
+
+// NewTransport returns a HTTP transport (round tripper) that dials connections
+// over the given network. Hostnames, including the names of peers, are
+// resolved by the network.
+func NewTransport(net network.Network) *stdhttp.Transport {
+	return &stdhttp.Transport{
+		// Proxies configured in the environment are for the host network, and
+		// are unlikely to be able to reach hosts on the noisy network.
+		Proxy: nil,
+		// Hostnames are resolved by the network, so lookups go through the
+		// DNS servers of the noisy network rather than the host's resolver.
+		DialContext:       net.DialContext,
+		ForceAttemptHTTP2: true,
+		MaxIdleConns:      100,
+		// Establishing connections over the userspace network stack is
+		// relatively expensive, so keep more idle connections per host than
+		// the default of 2.
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// NewClient returns a HTTP client that sends requests over the given network.
+func NewClient(net network.Network) *stdhttp.Client {
+	return &stdhttp.Client{
+		Transport: NewTransport(net),
+	}
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package httpnet_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	stdhttp "net/http"
+	"net/http/httptrace"
+	"testing"
+
+	"github.com/neilotoole/slogt"
+	"github.com/noisysockets/noisysockets"
+	"github.com/noisysockets/noisysockets/config/v1alpha1"
+	"github.com/noisysockets/noisysockets/httpnet"
+	"github.com/noisysockets/noisysockets/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	// Proxies in the environment should be ignored.
+	t.Setenv("HTTP_PROXY", "http://127.0.0.1:1")
+
+	logger := slogt.New(t)
+
+	serverPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	clientPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	serverNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "server",
+		ListenPort: 12362,
+		PrivateKey: serverPrivateKey.String(),
+		IPs:        []string{"10.13.0.1"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "client",
+				PublicKey: clientPrivateKey.PublicKey().String(),
+				IPs:       []string{"10.13.0.2"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, serverNet.Close())
+	})
+
+	clientNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "client",
+		ListenPort: 12363,
+		PrivateKey: clientPrivateKey.String(),
+		IPs:        []string{"10.13.0.2"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "server",
+				PublicKey: serverPrivateKey.PublicKey().String(),
+				Endpoint:  "localhost:12362",
+				IPs:       []string{"10.13.0.1"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, clientNet.Close())
+	})
+
+	lis, err := serverNet.Listen("tcp", ":80")
+	require.NoError(t, err)
+
+	srv := &stdhttp.Server{
+		Handler: stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+			fmt.Fprint(w, "Hello, world!")
+		}),
+	}
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	go func() {
+		if err := srv.Serve(lis); err != nil && !errors.Is(err, stdhttp.ErrServerClosed) {
+			logger.Error("Failed to serve", "error", err)
+		}
+	}()
+
+	client := httpnet.NewClient(clientNet)
+
+	var reused []bool
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			reused = append(reused, info.Reused)
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		req, err := stdhttp.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), stdhttp.MethodGet, "http://server", nil)
+		require.NoError(t, err)
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		require.Equal(t, stdhttp.StatusOK, resp.StatusCode)
+		require.Equal(t, "Hello, world!", string(body))
+	}
+
+	// The connection should have been reused.
+	require.Equal(t, []bool{false, true}, reused)
+}
@@ -0,0 +1,311 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package httpnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	stdnet "net"
+	stdhttp "net/http"
+	"net/http/httputil"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/noisysockets/noisysockets/network"
+)
+
+// ErrDestinationNotAllowed is returned when a destination is not in the
+// proxy's allowlist.
+var ErrDestinationNotAllowed = errors.New("destination not allowed")
+
+// ProxyOption configures a Proxy.
+type ProxyOption func(*proxyOptions)
+
+type proxyOptions struct {
+	allowed []string
+}
+
+// WithAllowedDestinations restricts the proxy to the given destinations.
+// Each destination is a hostname (eg. the name of a peer), a wildcard domain
+// (eg. "*.example.com"), an IP address or a CIDR prefix, optionally followed
+// by a port (eg. "server:80" or "10.7.0.0/24:443").
+func WithAllowedDestinations(destinations ...string) ProxyOption {
+	return func(o *proxyOptions) {
+		o.allowed = append(o.allowed, destinations...)
+	}
+}
+
+// Proxy is a HTTP forward proxy, it handles CONNECT requests and requests
+// with an absolute URI (as sent by HTTP_PROXY aware clients). Hostnames,
+// including the names of peers, are resolved by the network.
+type Proxy struct {
+	logger  *slog.Logger
+	net     network.Network
+	allowed []destination
+	forward *httputil.ReverseProxy
+}
+
+// NewProxy returns a HTTP proxy that forwards requests over the given
+// network.
+func NewProxy(logger *slog.Logger, net network.Network, opts ...ProxyOption) (*Proxy, error) {
+	var options proxyOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	p := &Proxy{
+		logger: logger,
+		net:    net,
+	}
+
+	for _, s := range options.allowed {
+		dst, err := parseDestination(s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse allowed destination %q: %w", s, err)
+		}
+		p.allowed = append(p.allowed, dst)
+	}
+
+	transport := NewTransport(net)
+	transport.DialContext = p.dialContext
+
+	p.forward = &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.Out.URL = r.In.URL
+			r.Out.Host = ""
+		},
+		Transport:    transport,
+		ErrorHandler: p.handleError,
+		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelDebug),
+	}
+
+	return p, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (p *Proxy) ServeHTTP(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+	if r.Method == stdhttp.MethodConnect {
+		p.handleConnect(w, r)
+		return
+	}
+
+	if !r.URL.IsAbs() {
+		stdhttp.Error(w, "Requests must use an absolute URI", stdhttp.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Scheme != "http" {
+		stdhttp.Error(w, "Unsupported scheme "+r.URL.Scheme, stdhttp.StatusBadRequest)
+		return
+	}
+
+	p.forward.ServeHTTP(w, r)
+}
+
+func (p *Proxy) handleConnect(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+	if _, _, err := stdnet.SplitHostPort(r.Host); err != nil {
+		stdhttp.Error(w, "Invalid destination", stdhttp.StatusBadRequest)
+		return
+	}
+
+	upstream, err := p.dialContext(r.Context(), "tcp", r.Host)
+	if err != nil {
+		p.handleError(w, r, err)
+		return
+	}
+	defer upstream.Close()
+
+	hijacker, ok := w.(stdhttp.Hijacker)
+	if !ok {
+		stdhttp.Error(w, "Hijacking not supported", stdhttp.StatusInternalServerError)
+		return
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		p.logger.Debug("Failed to hijack connection", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	// Clear any deadlines set by the server.
+	_ = conn.SetDeadline(time.Time{})
+
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return
+	}
+
+	p.logger.Debug("Proxying connection",
+		"client", r.RemoteAddr, "destination", r.Host)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		// The client may have sent data before receiving our response.
+		_, _ = io.Copy(upstream, rw.Reader)
+		closeWrite(upstream)
+	}()
+
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(conn, upstream)
+		closeWrite(conn)
+	}()
+
+	wg.Wait()
+}
+
+func (p *Proxy) handleError(w stdhttp.ResponseWriter, r *stdhttp.Request, err error) {
+	if errors.Is(err, ErrDestinationNotAllowed) {
+		p.logger.Warn("Denied proxy request",
+			"client", r.RemoteAddr, "destination", r.Host)
+		stdhttp.Error(w, "Destination not allowed", stdhttp.StatusForbidden)
+		return
+	}
+
+	p.logger.Debug("Failed to proxy request",
+		"client", r.RemoteAddr, "destination", r.Host, "error", err)
+
+	var netErr stdnet.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		stdhttp.Error(w, "Gateway timeout", stdhttp.StatusGatewayTimeout)
+		return
+	}
+
+	stdhttp.Error(w, "Bad gateway", stdhttp.StatusBadGateway)
+}
+
+// dialContext checks the destination against the allowlist before dialing
+// it over the network.
+func (p *Proxy) dialContext(ctx context.Context, network, address string) (stdnet.Conn, error) {
+	if len(p.allowed) == 0 {
+		return p.net.DialContext(ctx, network, address)
+	}
+
+	host, portStr, err := stdnet.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q: %w", portStr, err)
+	}
+
+	var addrs []netip.Addr
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{addr.Unmap()}
+	} else {
+		allAddr, err := p.net.LookupHost(host)
+		if err != nil {
+			return nil, &stdnet.OpError{Op: "dial", Net: network, Err: err}
+		}
+
+		for _, s := range allAddr {
+			if addr, err := netip.ParseAddr(s); err == nil {
+				addrs = append(addrs, addr.Unmap())
+			}
+		}
+	}
+
+	// Dial the checked address, rather than the hostname, so that a
+	// subsequent lookup can't return a different, disallowed, address.
+	for _, addr := range addrs {
+		if p.isAllowed(host, addr, uint16(port)) {
+			return p.net.DialContext(ctx, network, netip.AddrPortFrom(addr, uint16(port)).String())
+		}
+	}
+
+	return nil, fmt.Errorf("%s: %w", address, ErrDestinationNotAllowed)
+}
+
+func (p *Proxy) isAllowed(host string, addr netip.Addr, port uint16) bool {
+	for _, dst := range p.allowed {
+		if dst.matches(host, addr, port) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// destination is an entry in the allowlist.
+type destination struct {
+	// Exactly one of name, suffix or prefix is set.
+	name   string
+	suffix string
+	prefix netip.Prefix
+	// port is zero if any port is allowed.
+	port uint16
+}
+
+func parseDestination(s string) (destination, error) {
+	var dst destination
+
+	host := s
+	if h, portStr, err := stdnet.SplitHostPort(s); err == nil {
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil {
+			return dst, fmt.Errorf("invalid port %q: %w", portStr, err)
+		}
+		host, dst.port = h, uint16(port)
+	}
+
+	if host == "" {
+		return dst, errors.New("empty host")
+	}
+
+	if prefix, err := netip.ParsePrefix(host); err == nil {
+		dst.prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()).Masked()
+	} else if addr, err := netip.ParseAddr(host); err == nil {
+		dst.prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+	} else if strings.HasPrefix(host, "*.") {
+		dst.suffix = strings.ToLower(host[1:])
+	} else {
+		dst.name = strings.ToLower(host)
+	}
+
+	return dst, nil
+}
+
+func (dst *destination) matches(host string, addr netip.Addr, port uint16) bool {
+	if dst.port != 0 && dst.port != port {
+		return false
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	switch {
+	case dst.name != "":
+		return host == dst.name
+	case dst.suffix != "":
+		return strings.HasSuffix(host, dst.suffix)
+	default:
+		return dst.prefix.Contains(addr)
+	}
+}
+
+func closeWrite(conn stdnet.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		if err := cw.CloseWrite(); err == nil {
+			return
+		}
+	}
+
+	_ = conn.Close()
+}
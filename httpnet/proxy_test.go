@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package httpnet_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	stdnet "net"
+	stdhttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/neilotoole/slogt"
+	"github.com/noisysockets/noisysockets"
+	"github.com/noisysockets/noisysockets/config/v1alpha1"
+	"github.com/noisysockets/noisysockets/httpnet"
+	"github.com/noisysockets/noisysockets/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy(t *testing.T) {
+	logger := slogt.New(t)
+
+	serverPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	clientPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	serverNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "server",
+		ListenPort: 12366,
+		PrivateKey: serverPrivateKey.String(),
+		IPs:        []string{"10.15.0.1"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "client",
+				PublicKey: clientPrivateKey.PublicKey().String(),
+				IPs:       []string{"10.15.0.2"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, serverNet.Close())
+	})
+
+	clientNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "client",
+		ListenPort: 12367,
+		PrivateKey: clientPrivateKey.String(),
+		IPs:        []string{"10.15.0.2"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "server",
+				PublicKey: serverPrivateKey.PublicKey().String(),
+				Endpoint:  "localhost:12366",
+				IPs:       []string{"10.15.0.1"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, clientNet.Close())
+	})
+
+	for _, port := range []int{80, 8080} {
+		lis, err := serverNet.Listen("tcp", fmt.Sprintf(":%d", port))
+		require.NoError(t, err)
+
+		srv := &stdhttp.Server{
+			Handler: stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+				fmt.Fprint(w, "Hello, world!")
+			}),
+		}
+		t.Cleanup(func() {
+			_ = srv.Close()
+		})
+
+		go func() {
+			if err := srv.Serve(lis); err != nil && !errors.Is(err, stdhttp.ErrServerClosed) {
+				logger.Error("Failed to serve", "error", err)
+			}
+		}()
+	}
+
+	proxy, err := httpnet.NewProxy(logger, clientNet, httpnet.WithAllowedDestinations("server:80"))
+	require.NoError(t, err)
+
+	proxySrv := httptest.NewServer(proxy)
+	t.Cleanup(proxySrv.Close)
+
+	proxyURL, err := url.Parse(proxySrv.URL)
+	require.NoError(t, err)
+
+	client := &stdhttp.Client{
+		Transport: &stdhttp.Transport{
+			Proxy: stdhttp.ProxyURL(proxyURL),
+		},
+	}
+	t.Cleanup(client.CloseIdleConnections)
+
+	t.Run("Forward", func(t *testing.T) {
+		resp, err := client.Get("http://server")
+		require.NoError(t, err)
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		require.Equal(t, stdhttp.StatusOK, resp.StatusCode)
+		require.Equal(t, "Hello, world!", string(body))
+	})
+
+	t.Run("Connect", func(t *testing.T) {
+		conn, err := stdnet.Dial("tcp", proxyURL.Host)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+
+		_, err = fmt.Fprint(conn, "CONNECT server:80 HTTP/1.1\r\nHost: server:80\r\n\r\n")
+		require.NoError(t, err)
+
+		br := bufio.NewReader(conn)
+
+		resp, err := stdhttp.ReadResponse(br, &stdhttp.Request{Method: stdhttp.MethodConnect})
+		require.NoError(t, err)
+		require.Equal(t, stdhttp.StatusOK, resp.StatusCode)
+
+		req, err := stdhttp.NewRequest(stdhttp.MethodGet, "http://server", nil)
+		require.NoError(t, err)
+		require.NoError(t, req.Write(conn))
+
+		resp, err = stdhttp.ReadResponse(br, req)
+		require.NoError(t, err)
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		require.Equal(t, stdhttp.StatusOK, resp.StatusCode)
+		require.Equal(t, "Hello, world!", string(body))
+	})
+
+	t.Run("Not Allowed", func(t *testing.T) {
+		resp, err := client.Get("http://server:8080")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		require.Equal(t, stdhttp.StatusForbidden, resp.StatusCode)
+	})
+}
@@ -0,0 +1,316 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package noisysockets
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	stdnet "net"
+	"net/netip"
+	"time"
+
+	"golang.org/x/net/icmp"
+	xipv4 "golang.org/x/net/ipv4"
+	xipv6 "golang.org/x/net/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	gicmp "gvisor.dev/gvisor/pkg/tcpip/transport/icmp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// icmpNetworks are the supported ICMP network names, and whether they
+// accept IPv4 and IPv6 addresses respectively.
+var icmpNetworks = map[string][2]bool{
+	"ip4:icmp":      {true, false},
+	"ip4:1":         {true, false},
+	"ip6:ipv6-icmp": {false, true},
+	"ip6:58":        {false, true},
+	"ping":          {true, true},
+	"ping4":         {true, false},
+	"ping6":         {false, true},
+}
+
+// isICMPNetwork returns true if the given network name is an ICMP network.
+func isICMPNetwork(network string) bool {
+	_, ok := icmpNetworks[network]
+	return ok
+}
+
+// dialICMP creates an ICMP echo socket connected to the given host. As with
+// unprivileged ping sockets, the identifier of outgoing echo requests is
+// assigned by the stack and only matching echo replies are received.
+func (net *NoisySocketsNetwork) dialICMP(ctx context.Context, network, address string) (stdnet.Conn, error) {
+	pc, err := net.dialPingConn(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &udpConn{Conn: Conn{Conn: pc, pd: net.pd}}, nil
+}
+
+func (net *NoisySocketsNetwork) dialPingConn(ctx context.Context, network, address string) (*pingConn, error) {
+	accept := icmpNetworks[network]
+
+	allAddr, err := net.Resolver().LookupHost(ctx, address)
+	if err != nil {
+		return nil, &stdnet.OpError{Op: "dial", Net: network, Err: err}
+	}
+
+	var addr netip.Addr
+	for _, a := range allAddr {
+		ip, err := netip.ParseAddr(a)
+		if err == nil && ((ip.Is4() && accept[0]) || (ip.Is6() && accept[1])) {
+			addr = ip
+			break
+		}
+	}
+	if !addr.IsValid() {
+		return nil, &stdnet.OpError{Op: "dial", Net: network, Err: ErrNoSuitableAddress}
+	}
+
+	fa, pn := convertToFullAddr(netip.AddrPortFrom(addr, 0))
+	pc, err := net.newPingConn(nil, &fa, pn)
+	if err != nil {
+		return nil, err
+	}
+
+	return pc, nil
+}
+
+// listenICMP creates an unconnected ICMP echo socket bound to the given
+// local address (or all local addresses, if empty).
+func (net *NoisySocketsNetwork) listenICMP(network, address string) (stdnet.PacketConn, error) {
+	acceptV4, acceptV6 := icmpNetworks[network][0], icmpNetworks[network][1]
+
+	// ICMP and ICMPv6 are distinct protocols, so unlike TCP and UDP there are
+	// no dual-stack sockets. Prefer IPv4, as the ping command does.
+	if (address == "" || address == "0.0.0.0" || address == "::") && acceptV4 && acceptV6 && net.hasV4 {
+		acceptV6 = false
+	}
+
+	fa, pn, _, err := net.listenAddr(network, address, 0, acceptV4, acceptV6)
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := net.newPingConn(&fa, nil, pn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pingPacketConn{pingConn: pc, pd: net.pd}, nil
+}
+
+func (net *NoisySocketsNetwork) newPingConn(laddr, raddr *tcpip.FullAddress, pn tcpip.NetworkProtocolNumber) (*pingConn, error) {
+	transProto := gicmp.ProtocolNumber4
+	if pn == ipv6.ProtocolNumber {
+		transProto = gicmp.ProtocolNumber6
+	}
+
+	var wq waiter.Queue
+	ep, tcpipErr := net.stack.NewEndpoint(transProto, pn, &wq)
+	if tcpipErr != nil {
+		return nil, errors.New(tcpipErr.String())
+	}
+
+	if laddr != nil {
+		if tcpipErr := ep.Bind(*laddr); tcpipErr != nil {
+			ep.Close()
+			return nil, &stdnet.OpError{Op: "bind", Net: "ping", Addr: fullToIPAddr(*laddr), Err: errors.New(tcpipErr.String())}
+		}
+	}
+
+	if raddr != nil {
+		if tcpipErr := ep.Connect(*raddr); tcpipErr != nil {
+			ep.Close()
+			return nil, &stdnet.OpError{Op: "connect", Net: "ping", Addr: fullToIPAddr(*raddr), Err: errors.New(tcpipErr.String())}
+		}
+	}
+
+	return &pingConn{UDPConn: gonet.NewUDPConn(&wq, ep), is6: pn == ipv6.ProtocolNumber}, nil
+}
+
+// pingConn is an ICMP echo socket. Reads and writes are whole ICMP messages,
+// including the ICMP header.
+type pingConn struct {
+	// The netstack datagram adapter is not UDP specific, but it does use UDP
+	// addresses, which we translate to IP addresses.
+	*gonet.UDPConn
+	is6 bool
+}
+
+func (c *pingConn) LocalAddr() stdnet.Addr {
+	return udpToIPAddr(c.UDPConn.LocalAddr())
+}
+
+func (c *pingConn) RemoteAddr() stdnet.Addr {
+	return udpToIPAddr(c.UDPConn.RemoteAddr())
+}
+
+func (c *pingConn) ReadFrom(b []byte) (int, stdnet.Addr, error) {
+	n, addr, err := c.UDPConn.ReadFrom(b)
+	return n, udpToIPAddr(addr), err
+}
+
+func (c *pingConn) WriteTo(b []byte, addr stdnet.Addr) (int, error) {
+	var ip stdnet.IP
+	switch addr := addr.(type) {
+	case *Addr:
+		return c.WriteTo(b, addr.Addr)
+	case *stdnet.IPAddr:
+		ip = addr.IP
+	case *stdnet.UDPAddr:
+		ip = addr.IP
+	default:
+		return 0, &stdnet.OpError{Op: "write", Net: "ping", Addr: addr, Err: stdnet.InvalidAddrError("unsupported address type")}
+	}
+
+	if ip4 := ip.To4(); ip4 != nil && !c.is6 {
+		ip = ip4
+	}
+
+	return c.UDPConn.WriteTo(b, &stdnet.UDPAddr{IP: ip})
+}
+
+// pingPacketConn is an unconnected ICMP echo socket, which annotates the
+// source address of received messages with the identity of the peer.
+type pingPacketConn struct {
+	*pingConn
+	pd *peerDirectory
+}
+
+func (pc *pingPacketConn) ReadFrom(b []byte) (int, stdnet.Addr, error) {
+	n, addr, err := pc.pingConn.ReadFrom(b)
+	if addr == nil {
+		return n, nil, err
+	}
+
+	// Routed addresses are returned without an identity.
+	if peerAddr := peerAddr(pc.pd, addr); peerAddr != nil {
+		return n, peerAddr, err
+	}
+
+	return n, addr, err
+}
+
+// PingResult is the outcome of a successful Ping.
+type PingResult struct {
+	// Addr is the address that replied.
+	Addr netip.Addr
+	// Peer is the identity of the peer that replied, or nil if the address
+	// doesn't belong to a known peer.
+	Peer *PeerIdentity
+	// RTT is the round trip time of the echo request.
+	RTT time.Duration
+}
+
+// Ping sends an ICMP echo request to the given host (eg. the name of a peer)
+// and returns the round trip time, and the peer that replied, once a reply
+// has been received.
+func (net *NoisySocketsNetwork) Ping(ctx context.Context, host string) (*PingResult, error) {
+	pc, err := net.dialPingConn(ctx, "ping", host)
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+
+	remoteAddr := pc.RemoteAddr()
+
+	result := &PingResult{
+		Addr: netip.MustParseAddr(remoteAddr.String()),
+	}
+	if addr := peerAddr(net.pd, remoteAddr); addr != nil {
+		result.Peer, _ = PeerFromAddr(addr)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := pc.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	// Unblock any pending reads if the context is canceled.
+	stop := context.AfterFunc(ctx, func() {
+		_ = pc.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	var echoType, replyType icmp.Type = xipv4.ICMPTypeEcho, xipv4.ICMPTypeEchoReply
+	proto := gicmp.ProtocolNumber4
+	if pc.is6 {
+		echoType, replyType = xipv6.ICMPTypeEchoRequest, xipv6.ICMPTypeEchoReply
+		proto = gicmp.ProtocolNumber6
+	}
+
+	payload := make([]byte, 16)
+	if _, err := rand.Read(payload); err != nil {
+		return nil, fmt.Errorf("failed to generate payload: %w", err)
+	}
+
+	req, err := (&icmp.Message{
+		Type: echoType,
+		Body: &icmp.Echo{
+			// The identifier is overwritten by the netstack.
+			Seq:  1,
+			Data: payload,
+		},
+	}).Marshal(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal echo request: %w", err)
+	}
+
+	start := time.Now()
+
+	if _, err := pc.Write(req); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, err := pc.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+
+		reply, err := icmp.ParseMessage(int(proto), buf[:n])
+		if err != nil {
+			continue
+		}
+
+		if reply.Type != replyType {
+			continue
+		}
+
+		echo, ok := reply.Body.(*icmp.Echo)
+		if ok && echo.Seq == 1 && bytes.Equal(echo.Data, payload) {
+			result.RTT = time.Since(start)
+			return result, nil
+		}
+	}
+}
+
+func fullToIPAddr(addr tcpip.FullAddress) *stdnet.IPAddr {
+	return &stdnet.IPAddr{IP: stdnet.IP(addr.Addr.AsSlice())}
+}
+
+func udpToIPAddr(addr stdnet.Addr) stdnet.Addr {
+	ua, ok := addr.(*stdnet.UDPAddr)
+	if !ok || ua == nil {
+		return addr
+	}
+	return &stdnet.IPAddr{IP: ua.IP, Zone: ua.Zone}
+}
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package noisysockets
+
+import (
+	"context"
+	stdnet "net"
+
+	"github.com/noisysockets/noisysockets/types"
+)
+
+// PeerIdentity is the identity of a remote peer. The public key is
+// authenticated by the Noise handshake, so it can be used for authorization
+// without an additional layer such as mTLS.
+type PeerIdentity struct {
+	// PublicKey is the public key of the peer.
+	PublicKey types.NoisePublicKey
+	// Name is the optional hostname of the peer.
+	Name string
+}
+
+// PeerFromAddr returns the identity of the peer that owns the given address,
+// as returned by the RemoteAddr() method of connections on the network.
+// Addresses that are only routed via a peer (eg. hosts behind a default
+// gateway) have no identity.
+func PeerFromAddr(addr stdnet.Addr) (*PeerIdentity, bool) {
+	peerAddr, ok := addr.(*Addr)
+	if !ok {
+		return nil, false
+	}
+
+	return &PeerIdentity{
+		PublicKey: peerAddr.PublicKey(),
+		Name:      peerAddr.Name(),
+	}, true
+}
+
+// PeerFromConn returns the identity of the remote peer of a connection that
+// was dialed or accepted on the network. Wrapping connections, such as
+// *tls.Conn, are unwrapped as needed.
+func PeerFromConn(conn stdnet.Conn) (*PeerIdentity, bool) {
+	for conn != nil {
+		if peer, ok := PeerFromAddr(conn.RemoteAddr()); ok {
+			return peer, true
+		}
+
+		wrapper, ok := conn.(interface{ NetConn() stdnet.Conn })
+		if !ok {
+			break
+		}
+		conn = wrapper.NetConn()
+	}
+
+	return nil, false
+}
+
+type peerContextKey struct{}
+
+// ContextWithPeer returns a copy of the context carrying the given peer identity.
+func ContextWithPeer(ctx context.Context, peer *PeerIdentity) context.Context {
+	return context.WithValue(ctx, peerContextKey{}, peer)
+}
+
+// PeerFromContext returns the peer identity stored in the context, eg. by
+// ConnContext.
+func PeerFromContext(ctx context.Context) (*PeerIdentity, bool) {
+	peer, ok := ctx.Value(peerContextKey{}).(*PeerIdentity)
+	return peer, ok
+}
+
+// ConnContext stores the identity of the remote peer in the context, it is
+// intended to be used as the ConnContext of a http.Server so that handlers
+// can retrieve the peer with PeerFromContext(r.Context()).
+func ConnContext(ctx context.Context, conn stdnet.Conn) context.Context {
+	peer, ok := PeerFromConn(conn)
+	if !ok {
+		return ctx
+	}
+
+	return ContextWithPeer(ctx, peer)
+}
@@ -0,0 +1,245 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package allowedips implements a longest-prefix-match routing table that
+// maps IP prefixes (WireGuard AllowedIPs) to peer public keys.
+package allowedips
+
+import (
+	"net/netip"
+	"sort"
+
+	"github.com/noisysockets/noisysockets/types"
+)
+
+// A Table maps IP prefixes to peers using a binary trie.
+// The zero value for Table is an empty table ready to use.
+// Tables are unsafe for concurrent use.
+type Table struct {
+	v4, v6 *node
+}
+
+type node struct {
+	children [2]*node
+	hasPeer  bool
+	peer     types.NoisePublicKey
+}
+
+// Insert associates the prefix with the given peer, replacing any existing
+// association for the same prefix.
+func (t *Table) Insert(prefix netip.Prefix, peer types.NoisePublicKey) {
+	prefix = prefix.Masked()
+	addr := prefix.Addr()
+
+	bits := addrBytes(addr)
+
+	n := t.root(addr, true)
+	for i := 0; i < prefix.Bits(); i++ {
+		b := bitAt(bits, i)
+		if n.children[b] == nil {
+			n.children[b] = &node{}
+		}
+		n = n.children[b]
+	}
+
+	n.hasPeer = true
+	n.peer = peer
+}
+
+// Get returns the peer associated with exactly the given prefix.
+func (t *Table) Get(prefix netip.Prefix) (types.NoisePublicKey, bool) {
+	prefix = prefix.Masked()
+	addr := prefix.Addr()
+
+	bits := addrBytes(addr)
+
+	n := t.root(addr, false)
+	for i := 0; n != nil && i < prefix.Bits(); i++ {
+		n = n.children[bitAt(bits, i)]
+	}
+
+	if n == nil || !n.hasPeer {
+		return types.NoisePublicKey{}, false
+	}
+
+	return n.peer, true
+}
+
+// Lookup returns the peer associated with the longest prefix containing addr.
+func (t *Table) Lookup(addr netip.Addr) (types.NoisePublicKey, bool) {
+	addr = addr.Unmap()
+
+	bits := addrBytes(addr)
+
+	var peer types.NoisePublicKey
+	var found bool
+
+	n := t.root(addr, false)
+	for i := 0; n != nil; i++ {
+		if n.hasPeer {
+			peer, found = n.peer, true
+		}
+
+		if i == addr.BitLen() {
+			break
+		}
+
+		n = n.children[bitAt(bits, i)]
+	}
+
+	return peer, found
+}
+
+// Remove removes the association for exactly the given prefix.
+func (t *Table) Remove(prefix netip.Prefix) {
+	prefix = prefix.Masked()
+	addr := prefix.Addr()
+
+	root := t.root(addr, false)
+	if root == nil {
+		return
+	}
+
+	bits := addrBytes(addr)
+
+	var remove func(n *node, depth int) bool
+	remove = func(n *node, depth int) bool {
+		if depth == prefix.Bits() {
+			n.hasPeer = false
+		} else {
+			b := bitAt(bits, depth)
+			if child := n.children[b]; child != nil && remove(child, depth+1) {
+				n.children[b] = nil
+			}
+		}
+
+		return n.isEmpty()
+	}
+
+	if remove(root, 0) {
+		t.setRoot(addr, nil)
+	}
+}
+
+// RemoveByPeer removes all prefixes associated with the given peer.
+func (t *Table) RemoveByPeer(peer types.NoisePublicKey) {
+	var remove func(n *node) bool
+	remove = func(n *node) bool {
+		if n.hasPeer && n.peer == peer {
+			n.hasPeer = false
+		}
+
+		for b, child := range n.children {
+			if child != nil && remove(child) {
+				n.children[b] = nil
+			}
+		}
+
+		return n.isEmpty()
+	}
+
+	if t.v4 != nil && remove(t.v4) {
+		t.v4 = nil
+	}
+	if t.v6 != nil && remove(t.v6) {
+		t.v6 = nil
+	}
+}
+
+// Prefixes returns all prefixes in the table, ordered from most to least specific.
+func (t *Table) Prefixes() []netip.Prefix {
+	return t.prefixes(func(types.NoisePublicKey) bool { return true })
+}
+
+// PrefixesForPeer returns all prefixes associated with the given peer, ordered
+// from most to least specific.
+func (t *Table) PrefixesForPeer(peer types.NoisePublicKey) []netip.Prefix {
+	return t.prefixes(func(p types.NoisePublicKey) bool { return p == peer })
+}
+
+func (t *Table) prefixes(match func(types.NoisePublicKey) bool) []netip.Prefix {
+	var prefixes []netip.Prefix
+
+	var walk func(n *node, addr []byte, depth int, is4 bool)
+	walk = func(n *node, addr []byte, depth int, is4 bool) {
+		if n.hasPeer && match(n.peer) {
+			var prefixAddr netip.Addr
+			if is4 {
+				prefixAddr = netip.AddrFrom4([4]byte(addr))
+			} else {
+				prefixAddr = netip.AddrFrom16([16]byte(addr))
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(prefixAddr, depth))
+		}
+
+		for b, child := range n.children {
+			if child == nil {
+				continue
+			}
+
+			childAddr := append([]byte(nil), addr...)
+			if b == 1 {
+				childAddr[depth/8] |= 1 << (7 - depth%8)
+			}
+			walk(child, childAddr, depth+1, is4)
+		}
+	}
+
+	if t.v4 != nil {
+		walk(t.v4, make([]byte, 4), 0, true)
+	}
+	if t.v6 != nil {
+		walk(t.v6, make([]byte, 16), 0, false)
+	}
+
+	sort.SliceStable(prefixes, func(i, j int) bool {
+		return prefixes[i].Bits() > prefixes[j].Bits()
+	})
+
+	return prefixes
+}
+
+func (t *Table) root(addr netip.Addr, create bool) *node {
+	n := t.v6
+	if addr.Is4() {
+		n = t.v4
+	}
+
+	if n == nil && create {
+		n = &node{}
+		t.setRoot(addr, n)
+	}
+
+	return n
+}
+
+func (t *Table) setRoot(addr netip.Addr, n *node) {
+	if addr.Is4() {
+		t.v4 = n
+	} else {
+		t.v6 = n
+	}
+}
+
+func (n *node) isEmpty() bool {
+	return !n.hasPeer && n.children[0] == nil && n.children[1] == nil
+}
+
+func addrBytes(addr netip.Addr) []byte {
+	if addr.Is4() {
+		b := addr.As4()
+		return b[:]
+	}
+	b := addr.As16()
+	return b[:]
+}
+
+func bitAt(b []byte, i int) int {
+	return int(b[i/8]>>(7-i%8)) & 1
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package allowedips_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/noisysockets/noisysockets/internal/allowedips"
+	"github.com/noisysockets/noisysockets/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTable(t *testing.T) {
+	var gateway, site, host types.NoisePublicKey
+	gateway[0] = 1
+	site[0] = 2
+	host[0] = 3
+
+	var table allowedips.Table
+	table.Insert(netip.MustParsePrefix("0.0.0.0/0"), gateway)
+	table.Insert(netip.MustParsePrefix("::/0"), gateway)
+	table.Insert(netip.MustParsePrefix("10.8.0.0/24"), site)
+	table.Insert(netip.MustParsePrefix("10.8.0.7/32"), host)
+	table.Insert(netip.MustParsePrefix("fd00::/64"), site)
+
+	lookup := func(addr string) types.NoisePublicKey {
+		pk, ok := table.Lookup(netip.MustParseAddr(addr))
+		require.True(t, ok, addr)
+		return pk
+	}
+
+	require.Equal(t, gateway, lookup("1.1.1.1"))
+	require.Equal(t, site, lookup("10.8.0.1"))
+	require.Equal(t, host, lookup("10.8.0.7"))
+	require.Equal(t, host, lookup("::ffff:10.8.0.7"))
+	require.Equal(t, site, lookup("fd00::1"))
+	require.Equal(t, gateway, lookup("2001:db8::1"))
+
+	pk, ok := table.Get(netip.MustParsePrefix("10.8.0.0/24"))
+	require.True(t, ok)
+	require.Equal(t, site, pk)
+
+	_, ok = table.Get(netip.MustParsePrefix("10.8.0.0/16"))
+	require.False(t, ok)
+
+	require.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("fd00::/64"),
+		netip.MustParsePrefix("10.8.0.7/32"),
+		netip.MustParsePrefix("10.8.0.0/24"),
+		netip.MustParsePrefix("0.0.0.0/0"),
+		netip.MustParsePrefix("::/0"),
+	}, table.Prefixes())
+
+	table.Remove(netip.MustParsePrefix("10.8.0.7/32"))
+	require.Equal(t, site, lookup("10.8.0.7"))
+
+	table.RemoveByPeer(site)
+	require.Equal(t, gateway, lookup("10.8.0.7"))
+	require.Equal(t, gateway, lookup("fd00::1"))
+	require.Empty(t, table.PrefixesForPeer(site))
+
+	table.RemoveByPeer(gateway)
+	_, ok = table.Lookup(netip.MustParseAddr("1.1.1.1"))
+	require.False(t, ok)
+	require.Empty(t, table.Prefixes())
+}
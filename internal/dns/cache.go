@@ -0,0 +1,210 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dns
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// DefaultCacheSize is the default maximum number of responses to cache.
+	DefaultCacheSize = 4096
+	// maxCacheTTL bounds how long a positive response may be cached.
+	maxCacheTTL = 24 * time.Hour
+	// maxNegativeCacheTTL bounds how long a negative response may be cached
+	// (RFC 2308 section 5).
+	maxNegativeCacheTTL = 3 * time.Hour
+)
+
+// Cache is a TTL-aware cache of DNS responses, keyed by question. Negative
+// responses (NXDOMAIN and NODATA) are cached according to RFC 2308. When full,
+// the least recently used response is evicted.
+type Cache struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[dns.Question]*list.Element
+	lru        *list.List
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+type cacheEntry struct {
+	question dns.Question
+	msg      *dns.Msg
+	stored   time.Time
+	expires  time.Time
+}
+
+// NewCache creates a new cache holding at most maxEntries responses.
+func NewCache(maxEntries int) *Cache {
+	return &Cache{
+		maxEntries: maxEntries,
+		entries:    make(map[dns.Question]*list.Element),
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+// Get returns a copy of the cached response to the given question, with the
+// TTLs of its records reduced by the time it has spent in the cache.
+func (c *Cache) Get(q dns.Question) (*dns.Msg, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	q.Name = dns.CanonicalName(q.Name)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[q]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+
+	now := c.now()
+	if !now.Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, q)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+
+	elapsed := uint32(now.Sub(entry.stored) / time.Second)
+
+	msg := entry.msg.Copy()
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+
+			if rr.Header().Ttl > elapsed {
+				rr.Header().Ttl -= elapsed
+			} else {
+				rr.Header().Ttl = 0
+			}
+		}
+	}
+
+	return msg, true
+}
+
+// Put caches the response to a query, if it is cacheable.
+func (c *Cache) Put(msg *dns.Msg) {
+	if c == nil || c.maxEntries <= 0 || len(msg.Question) != 1 {
+		return
+	}
+
+	ttl, ok := cacheTTL(msg)
+	if !ok || ttl <= 0 {
+		return
+	}
+
+	q := msg.Question[0]
+	q.Name = dns.CanonicalName(q.Name)
+
+	now := c.now()
+	entry := &cacheEntry{
+		question: q,
+		msg:      msg.Copy(),
+		stored:   now,
+		expires:  now.Add(ttl),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[q]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[q] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).question)
+	}
+}
+
+// Flush removes all cached responses.
+func (c *Cache) Flush() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+	c.lru.Init()
+}
+
+// Len returns the number of cached responses.
+func (c *Cache) Len() int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// cacheTTL returns how long a response may be cached for.
+func cacheTTL(msg *dns.Msg) (time.Duration, bool) {
+	if msg.Truncated {
+		return 0, false
+	}
+
+	switch msg.Rcode {
+	case dns.RcodeSuccess:
+		if len(msg.Answer) > 0 {
+			minTTL := msg.Answer[0].Header().Ttl
+			for _, rr := range msg.Answer[1:] {
+				minTTL = min(minTTL, rr.Header().Ttl)
+			}
+
+			return min(time.Duration(minTTL)*time.Second, maxCacheTTL), true
+		}
+
+		// NODATA.
+		return negativeCacheTTL(msg)
+	case dns.RcodeNameError:
+		return negativeCacheTTL(msg)
+	default:
+		return 0, false
+	}
+}
+
+// negativeCacheTTL returns how long a negative response may be cached for,
+// which is the minimum of the SOA record's TTL and its MINIMUM field. Negative
+// responses without a SOA record must not be cached (RFC 2308 section 5).
+func negativeCacheTTL(msg *dns.Msg) (time.Duration, bool) {
+	for _, rr := range msg.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl := time.Duration(min(soa.Hdr.Ttl, soa.Minttl)) * time.Second
+			return min(ttl, maxNegativeCacheTTL), true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dns
+
+import (
+	stdnet "net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Now()
+
+	c := NewCache(2)
+	c.now = func() time.Time { return now }
+
+	question := func(name string) dns.Question {
+		return dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	}
+
+	answer := func(name string, ttl uint32) *dns.Msg {
+		msg := new(dns.Msg).SetQuestion(name, dns.TypeA)
+		msg.Response = true
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+			A:   stdnet.ParseIP("192.168.1.2"),
+		})
+		return msg
+	}
+
+	negative := func(name string, rcode int, soaTTL, minTTL uint32) *dns.Msg {
+		msg := new(dns.Msg).SetQuestion(name, dns.TypeA)
+		msg.Response = true
+		msg.Rcode = rcode
+		msg.Ns = append(msg.Ns, &dns.SOA{
+			Hdr:    dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: soaTTL},
+			Ns:     "ns.example.com.",
+			Mbox:   "hostmaster.example.com.",
+			Minttl: minTTL,
+		})
+		return msg
+	}
+
+	t.Run("Positive", func(t *testing.T) {
+		t.Cleanup(c.Flush)
+
+		c.Put(answer("www.example.com.", 60))
+
+		// Names are case insensitive.
+		msg, ok := c.Get(question("WWW.example.com."))
+		require.True(t, ok)
+		require.Len(t, msg.Answer, 1)
+		require.Equal(t, uint32(60), msg.Answer[0].Header().Ttl)
+
+		now = now.Add(20 * time.Second)
+
+		msg, ok = c.Get(question("www.example.com."))
+		require.True(t, ok)
+		require.Equal(t, uint32(40), msg.Answer[0].Header().Ttl)
+
+		now = now.Add(40 * time.Second)
+
+		_, ok = c.Get(question("www.example.com."))
+		require.False(t, ok)
+		require.Zero(t, c.Len())
+	})
+
+	t.Run("Negative", func(t *testing.T) {
+		t.Cleanup(c.Flush)
+
+		// The TTL is the lesser of the SOA TTL and MINIMUM fields.
+		c.Put(negative("missing.example.com.", dns.RcodeNameError, 300, 30))
+
+		msg, ok := c.Get(question("missing.example.com."))
+		require.True(t, ok)
+		require.Equal(t, dns.RcodeNameError, msg.Rcode)
+
+		now = now.Add(30 * time.Second)
+
+		_, ok = c.Get(question("missing.example.com."))
+		require.False(t, ok)
+
+		// NODATA responses are also cached.
+		c.Put(negative("nodata.example.com.", dns.RcodeSuccess, 30, 300))
+
+		_, ok = c.Get(question("nodata.example.com."))
+		require.True(t, ok)
+
+		// But negative responses without a SOA record are not.
+		msg = negative("nosoa.example.com.", dns.RcodeNameError, 30, 30)
+		msg.Ns = nil
+		c.Put(msg)
+
+		_, ok = c.Get(question("nosoa.example.com."))
+		require.False(t, ok)
+
+		// Nor are server failures.
+		msg = negative("servfail.example.com.", dns.RcodeServerFailure, 30, 30)
+		c.Put(msg)
+
+		_, ok = c.Get(question("servfail.example.com."))
+		require.False(t, ok)
+	})
+
+	t.Run("Eviction", func(t *testing.T) {
+		t.Cleanup(c.Flush)
+
+		c.Put(answer("a.example.com.", 60))
+		c.Put(answer("b.example.com.", 60))
+
+		// Make b the least recently used.
+		_, ok := c.Get(question("a.example.com."))
+		require.True(t, ok)
+
+		c.Put(answer("c.example.com.", 60))
+		require.Equal(t, 2, c.Len())
+
+		_, ok = c.Get(question("b.example.com."))
+		require.False(t, ok)
+
+		_, ok = c.Get(question("a.example.com."))
+		require.True(t, ok)
+
+		_, ok = c.Get(question("c.example.com."))
+		require.True(t, ok)
+	})
+
+	t.Run("Flush", func(t *testing.T) {
+		c.Put(answer("www.example.com.", 60))
+		require.Equal(t, 1, c.Len())
+
+		c.Flush()
+		require.Zero(t, c.Len())
+
+		_, ok := c.Get(question("www.example.com."))
+		require.False(t, ok)
+	})
+}
@@ -13,10 +13,13 @@ import (
 	"context"
 	stdnet "net"
 	"net/netip"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	miekgdns "github.com/miekg/dns"
 	"github.com/noisysockets/noisysockets/internal/dns"
+	"github.com/noisysockets/noisysockets/internal/dns/serveraddr"
 	"github.com/noisysockets/noisysockets/network"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -59,7 +62,11 @@ func TestLookupHost(t *testing.T) {
 	time.Sleep(time.Second)
 
 	// Perform a DNS query.
-	addrs, err := dns.LookupHost(network.Host(), []netip.AddrPort{dnsServer}, "www.noisysockets.github.com")
+	resolver := dns.NewResolver(network.Host(), nil, dns.ResolverConfig{
+		Servers: []serveraddr.Addr{{AddrPort: dnsServer}},
+	})
+
+	addrs, err := resolver.LookupHost(ctx, "www.noisysockets.github.com")
 	require.NoError(t, err)
 
 	require.Len(t, addrs, 2)
@@ -67,3 +74,116 @@ func TestLookupHost(t *testing.T) {
 	assert.Equal(t, "192.168.1.2", addrs[0].String())
 	assert.Equal(t, "2001:db8::1", addrs[1].String())
 }
+
+func TestLookupHostTCP(t *testing.T) {
+	lis, err := stdnet.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	pc, err := stdnet.ListenPacket("udp", lis.Addr().String())
+	require.NoError(t, err)
+
+	var udpQueries, tcpQueries atomic.Int32
+	var ednsUDPSize atomic.Uint32
+
+	// Answers over UDP are always truncated.
+	handler := miekgdns.HandlerFunc(func(w miekgdns.ResponseWriter, r *miekgdns.Msg) {
+		resp := new(miekgdns.Msg).SetReply(r)
+
+		if w.LocalAddr().Network() == "udp" {
+			udpQueries.Add(1)
+			if opt := r.IsEdns0(); opt != nil {
+				ednsUDPSize.Store(uint32(opt.UDPSize()))
+			}
+			resp.Truncated = true
+		} else {
+			tcpQueries.Add(1)
+			if r.Question[0].Qtype == miekgdns.TypeA {
+				resp.Answer = append(resp.Answer, &miekgdns.A{
+					Hdr: miekgdns.RR_Header{Name: r.Question[0].Name, Rrtype: miekgdns.TypeA, Class: miekgdns.ClassINET, Ttl: 60},
+					A:   stdnet.ParseIP("192.168.1.2"),
+				})
+			} else {
+				resp.Ns = append(resp.Ns, &miekgdns.SOA{
+					Hdr:    miekgdns.RR_Header{Name: "github.com.", Rrtype: miekgdns.TypeSOA, Class: miekgdns.ClassINET, Ttl: 60},
+					Ns:     "ns.github.com.",
+					Mbox:   "hostmaster.github.com.",
+					Minttl: 60,
+				})
+			}
+		}
+
+		assert.NoError(t, w.WriteMsg(resp))
+	})
+
+	udpServer := &miekgdns.Server{PacketConn: pc, Handler: handler}
+	tcpServer := &miekgdns.Server{Listener: lis, Handler: handler}
+
+	for _, srv := range []*miekgdns.Server{udpServer, tcpServer} {
+		go func() {
+			_ = srv.ActivateAndServe()
+		}()
+		t.Cleanup(func() {
+			_ = srv.Shutdown()
+		})
+	}
+
+	dnsServer := netip.MustParseAddrPort(lis.Addr().String())
+
+	ctx := context.Background()
+
+	t.Run("Truncated", func(t *testing.T) {
+		resolver := dns.NewResolver(network.Host(), nil, dns.ResolverConfig{
+			Servers: []serveraddr.Addr{{AddrPort: dnsServer}},
+		})
+
+		addrs, err := resolver.LookupHost(ctx, "www.noisysockets.github.com")
+		require.NoError(t, err)
+
+		require.Len(t, addrs, 1)
+		assert.Equal(t, "192.168.1.2", addrs[0].String())
+
+		// Every query should have been retried over TCP.
+		assert.NotZero(t, udpQueries.Load())
+		assert.Equal(t, udpQueries.Load(), tcpQueries.Load())
+		assert.NotZero(t, ednsUDPSize.Load())
+	})
+
+	t.Run("TCP Only", func(t *testing.T) {
+		udpQueries.Store(0)
+
+		serverAddr, err := serveraddr.Parse("tcp://" + dnsServer.String())
+		require.NoError(t, err)
+
+		resolver := dns.NewResolver(network.Host(), nil, dns.ResolverConfig{
+			Servers: []serveraddr.Addr{serverAddr},
+		})
+
+		addrs, err := resolver.LookupHost(ctx, "www.noisysockets.github.com")
+		require.NoError(t, err)
+
+		require.Len(t, addrs, 1)
+		assert.Equal(t, "192.168.1.2", addrs[0].String())
+
+		assert.Zero(t, udpQueries.Load())
+	})
+
+	t.Run("Cached", func(t *testing.T) {
+		resolver := dns.NewResolver(network.Host(), dns.NewCache(dns.DefaultCacheSize), dns.ResolverConfig{
+			Servers: []serveraddr.Addr{{AddrPort: dnsServer}},
+		})
+
+		addrs, err := resolver.LookupHost(ctx, "www.noisysockets.github.com")
+		require.NoError(t, err)
+		require.Len(t, addrs, 1)
+
+		queries := tcpQueries.Load()
+
+		// The second lookup should be answered from the cache.
+		addrs, err = resolver.LookupHost(ctx, "www.noisysockets.github.com")
+		require.NoError(t, err)
+		require.Len(t, addrs, 1)
+		assert.Equal(t, "192.168.1.2", addrs[0].String())
+
+		assert.Equal(t, queries, tcpQueries.Load())
+	})
+}
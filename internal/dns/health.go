@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dns
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/noisysockets/noisysockets/internal/dns/serveraddr"
+)
+
+const (
+	// minFailureBackoff is how long a server is deprioritized for after its
+	// first failure, it doubles with each consecutive failure.
+	minFailureBackoff = time.Second
+	// maxFailureBackoff bounds how long a server is deprioritized for.
+	maxFailureBackoff = 5 * time.Minute
+)
+
+// serverHealth tracks failing DNS servers, so that they can be deprioritized.
+type serverHealth struct {
+	mu      sync.Mutex
+	servers map[serveraddr.Addr]*serverState
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+type serverState struct {
+	failures   int
+	retryAfter time.Time
+}
+
+func newServerHealth() *serverHealth {
+	return &serverHealth{
+		servers: make(map[serveraddr.Addr]*serverState),
+		now:     time.Now,
+	}
+}
+
+// success records that the server responded.
+func (h *serverHealth) success(server serveraddr.Addr) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.servers, server)
+}
+
+// failure records that the server failed to respond, it will be
+// deprioritized for an exponentially increasing period.
+func (h *serverHealth) failure(server serveraddr.Addr) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state, ok := h.servers[server]
+	if !ok {
+		state = &serverState{}
+		h.servers[server] = state
+	}
+
+	backoff := maxFailureBackoff
+	if state.failures < 16 {
+		backoff = min(minFailureBackoff<<state.failures, maxFailureBackoff)
+	}
+
+	state.failures++
+	state.retryAfter = h.now().Add(backoff)
+}
+
+// order returns the servers in the order they should be tried. Healthy
+// servers keep their configured order, and are followed by the failing
+// servers, soonest to recover first.
+func (h *serverHealth) order(servers []serveraddr.Addr) []serveraddr.Addr {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+
+	retryAfter := func(server serveraddr.Addr) time.Time {
+		if state, ok := h.servers[server]; ok && now.Before(state.retryAfter) {
+			return state.retryAfter
+		}
+		return time.Time{}
+	}
+
+	ordered := slices.Clone(servers)
+	slices.SortStableFunc(ordered, func(a, b serveraddr.Addr) int {
+		return retryAfter(a).Compare(retryAfter(b))
+	})
+
+	return ordered
+}
+
+// prune forgets about servers that are no longer configured.
+func (h *serverHealth) prune(servers []serveraddr.Addr) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for server := range h.servers {
+		if !slices.Contains(servers, server) {
+			delete(h.servers, server)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dns
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/noisysockets/noisysockets/internal/dns/serveraddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerHealth(t *testing.T) {
+	now := time.Now()
+
+	h := newServerHealth()
+	h.now = func() time.Time { return now }
+
+	a := serveraddr.Addr{AddrPort: netip.MustParseAddrPort("10.0.0.1:53")}
+	b := serveraddr.Addr{AddrPort: netip.MustParseAddrPort("10.0.0.2:53")}
+	c := serveraddr.Addr{AddrPort: netip.MustParseAddrPort("10.0.0.3:53")}
+	servers := []serveraddr.Addr{a, b, c}
+
+	require.Equal(t, servers, h.order(servers))
+
+	// Failing servers are moved to the back.
+	h.failure(a)
+	h.failure(a)
+	h.failure(b)
+	require.Equal(t, []serveraddr.Addr{c, b, a}, h.order(servers))
+
+	// Until their backoff has elapsed.
+	now = now.Add(minFailureBackoff)
+	require.Equal(t, []serveraddr.Addr{b, c, a}, h.order(servers))
+
+	now = now.Add(minFailureBackoff)
+	require.Equal(t, servers, h.order(servers))
+
+	// A successful response resets the backoff.
+	h.failure(a)
+	h.success(a)
+	require.Equal(t, servers, h.order(servers))
+
+	// Servers that are no longer configured are forgotten.
+	h.failure(c)
+	h.prune([]serveraddr.Addr{a, b})
+	require.NotContains(t, h.servers, c)
+}
@@ -0,0 +1,294 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	stdnet "net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/miekg/dns"
+	"github.com/noisysockets/noisysockets/internal/dns/addrselect"
+	"github.com/noisysockets/noisysockets/internal/dns/serveraddr"
+	"github.com/noisysockets/noisysockets/network"
+)
+
+const (
+	// DefaultTimeout is the default timeout for each attempt to query a DNS
+	// server.
+	DefaultTimeout = 2 * time.Second
+	// staggerDelay is how long to wait for a response from a DNS server,
+	// before also querying the next server.
+	staggerDelay = 200 * time.Millisecond
+)
+
+var ErrNoServers = errors.New("no DNS servers configured")
+
+// ResolverConfig is the configuration for a resolver.
+type ResolverConfig struct {
+	// Servers are the DNS servers to query, in order of preference.
+	Servers []serveraddr.Addr
+	// Timeout is the timeout for each attempt to query a DNS server, if zero
+	// DefaultTimeout is used.
+	Timeout time.Duration
+}
+
+// Resolver is a DNS stub resolver. Queries are sent to the preferred DNS
+// server first, if it doesn't respond promptly the next server is also
+// queried (and so on), and the first usable response wins. Servers that fail
+// are deprioritized for a while.
+type Resolver struct {
+	net    network.Network
+	cache  *Cache
+	health *serverHealth
+	mu     sync.RWMutex
+	conf   ResolverConfig
+}
+
+// NewResolver creates a new resolver that queries DNS servers over the given
+// network. Responses are stored in, and if still fresh served from, the given
+// cache (which may be nil).
+func NewResolver(net network.Network, cache *Cache, conf ResolverConfig) *Resolver {
+	return &Resolver{
+		net:    net,
+		cache:  cache,
+		health: newServerHealth(),
+		conf:   conf,
+	}
+}
+
+// Config returns the current configuration of the resolver.
+func (r *Resolver) Config() ResolverConfig {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.conf
+}
+
+// SetConfig updates the configuration of the resolver. If the servers have
+// changed, the cache is flushed.
+func (r *Resolver) SetConfig(conf ResolverConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !slices.Equal(conf.Servers, r.conf.Servers) {
+		// Responses from the previous servers may no longer be valid.
+		r.cache.Flush()
+		r.health.prune(conf.Servers)
+	}
+
+	r.conf = conf
+}
+
+// FlushCache removes all cached responses.
+func (r *Resolver) FlushCache() {
+	r.cache.Flush()
+}
+
+// LookupHost looks up the addresses of the given host.
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]netip.Addr, error) {
+	addrs, _, err := r.LookupHostWithTTL(ctx, host)
+	return addrs, err
+}
+
+// LookupHostWithTTL is like LookupHost but also returns the minimum TTL of the
+// returned records, ie. how long the result may be cached for.
+func (r *Resolver) LookupHostWithTTL(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	var queryTypes []uint16
+	if r.net.HasIPv4() {
+		queryTypes = append(queryTypes, dns.TypeA)
+	}
+	if r.net.HasIPv6() {
+		queryTypes = append(queryTypes, dns.TypeAAAA)
+	}
+
+	// Query for all address families in parallel.
+	responses := make([]*dns.Msg, len(queryTypes))
+	errs := make([]error, len(queryTypes))
+
+	var wg sync.WaitGroup
+	for i, queryType := range queryTypes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i], errs[i] = r.Query(ctx, host, queryType)
+		}()
+	}
+	wg.Wait()
+
+	var addrs []netip.Addr
+	var ttl uint32
+	var queryResult *multierror.Error
+
+	for i, in := range responses {
+		if errs[i] != nil {
+			queryResult = multierror.Append(queryResult, errs[i])
+			continue
+		}
+
+		for _, rr := range in.Answer {
+			switch rr := rr.(type) {
+			case *dns.A:
+				addrs = append(addrs, netip.AddrFrom4([4]byte(rr.A.To4())))
+			case *dns.AAAA:
+				addrs = append(addrs, netip.AddrFrom16([16]byte(rr.AAAA.To16())))
+			default:
+				continue
+			}
+
+			if len(addrs) == 1 || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+		}
+	}
+
+	if len(addrs) > 0 {
+		addrselect.SortByRFC6724(r.net, addrs)
+		return addrs, time.Duration(ttl) * time.Second, nil
+	}
+
+	if queryResult != nil {
+		return nil, 0, &stdnet.DNSError{Err: queryResult.Error(), Name: host}
+	}
+
+	return nil, 0, &stdnet.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// Query looks up records of the given type for a name, returning the
+// response of the first DNS server to answer.
+func (r *Resolver) Query(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.SetEdns0(ednsUDPSize, false)
+
+	if resp, ok := r.cache.Get(msg.Question[0]); ok {
+		return resp, nil
+	}
+
+	conf := r.Config()
+	if len(conf.Servers) == 0 {
+		return nil, ErrNoServers
+	}
+
+	client := &dns.Client{Timeout: conf.Timeout}
+	if client.Timeout == 0 {
+		client.Timeout = DefaultTimeout
+	}
+
+	resp, err := r.race(ctx, client, msg, r.health.order(conf.Servers))
+	if err != nil {
+		return nil, err
+	}
+
+	r.cache.Put(resp)
+
+	return resp, nil
+}
+
+type raceResult struct {
+	index int
+	resp  *dns.Msg
+	err   error
+}
+
+// race queries the servers in order, starting a query to the next server
+// every staggerDelay (or as soon as a query fails), until one of them
+// responds. Servers that fail, or are outpaced by a server queried after
+// them, are recorded as unhealthy.
+func (r *Resolver) race(ctx context.Context, client *dns.Client, msg *dns.Msg, servers []serveraddr.Addr) (*dns.Msg, error) {
+	// Cancel any outstanding queries once we have a response.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Buffered so that outstanding queries never block.
+	results := make(chan raceResult, len(servers))
+
+	var next, inflight int
+	done := make([]bool, len(servers))
+	startNext := func() {
+		index := next
+		next++
+		inflight++
+
+		go func() {
+			// The message is modified when it is sent, so each query needs its
+			// own copy.
+			resp, err := Exchange(ctx, r.net, client, msg.Copy(), servers[index])
+			results <- raceResult{index: index, resp: resp, err: err}
+		}()
+	}
+
+	startNext()
+
+	stagger := time.NewTimer(staggerDelay)
+	defer stagger.Stop()
+
+	var queryResult *multierror.Error
+	for inflight > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-stagger.C:
+			if next < len(servers) {
+				startNext()
+				stagger.Reset(staggerDelay)
+			}
+		case result := <-results:
+			inflight--
+			done[result.index] = true
+			server := servers[result.index]
+
+			err := result.err
+			if err == nil {
+				switch result.resp.Rcode {
+				case dns.RcodeServerFailure, dns.RcodeRefused:
+					err = fmt.Errorf("DNS server %s responded with %s",
+						server, dns.RcodeToString[result.resp.Rcode])
+				default:
+					r.health.success(server)
+					for i := range result.index {
+						if !done[i] {
+							r.health.failure(servers[i])
+						}
+					}
+					return result.resp, nil
+				}
+			}
+
+			// The query was canceled, which is not the server's fault.
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			r.health.failure(server)
+			queryResult = multierror.Append(queryResult, err)
+
+			// Don't wait for the stagger delay to try the next server.
+			if next < len(servers) {
+				startNext()
+				if !stagger.Stop() {
+					select {
+					case <-stagger.C:
+					default:
+					}
+				}
+				stagger.Reset(staggerDelay)
+			}
+		}
+	}
+
+	return nil, queryResult.ErrorOrNil()
+}
@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dns_test
+
+import (
+	"context"
+	stdnet "net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	miekgdns "github.com/miekg/dns"
+	"github.com/noisysockets/noisysockets/internal/dns"
+	"github.com/noisysockets/noisysockets/internal/dns/serveraddr"
+	"github.com/noisysockets/noisysockets/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolver(t *testing.T) {
+	// A DNS server that never responds.
+	deadPC, err := stdnet.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = deadPC.Close()
+	})
+
+	var deadQueries atomic.Int32
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := deadPC.ReadFrom(buf); err != nil {
+				return
+			}
+			deadQueries.Add(1)
+		}
+	}()
+
+	pc, err := stdnet.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := &miekgdns.Server{PacketConn: pc, Handler: miekgdns.HandlerFunc(func(w miekgdns.ResponseWriter, r *miekgdns.Msg) {
+		resp := new(miekgdns.Msg).SetReply(r)
+
+		switch r.Question[0].Qtype {
+		case miekgdns.TypeA:
+			resp.Answer = append(resp.Answer, &miekgdns.A{
+				Hdr: miekgdns.RR_Header{Name: r.Question[0].Name, Rrtype: miekgdns.TypeA, Class: miekgdns.ClassINET, Ttl: 60},
+				A:   stdnet.ParseIP("192.168.1.2"),
+			})
+		case miekgdns.TypeAAAA:
+			resp.Answer = append(resp.Answer, &miekgdns.AAAA{
+				Hdr:  miekgdns.RR_Header{Name: r.Question[0].Name, Rrtype: miekgdns.TypeAAAA, Class: miekgdns.ClassINET, Ttl: 60},
+				AAAA: stdnet.ParseIP("2001:db8::1"),
+			})
+		}
+
+		assert.NoError(t, w.WriteMsg(resp))
+	})}
+	go func() {
+		_ = srv.ActivateAndServe()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown()
+	})
+
+	timeout := 5 * time.Second
+	resolver := dns.NewResolver(network.Host(), nil, dns.ResolverConfig{
+		Servers: []serveraddr.Addr{
+			{AddrPort: netip.MustParseAddrPort(deadPC.LocalAddr().String())},
+			{AddrPort: netip.MustParseAddrPort(pc.LocalAddr().String())},
+		},
+		Timeout: timeout,
+	})
+
+	ctx := context.Background()
+
+	// The second server should be raced, rather than waiting for the first
+	// server to time out.
+	start := time.Now()
+	addrs, err := resolver.LookupHost(ctx, "www.noisysockets.github.com")
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), timeout)
+
+	require.Len(t, addrs, 2)
+	require.Equal(t, int32(2), deadQueries.Load())
+
+	// The dead server should now be deprioritized.
+	addrs, err = resolver.LookupHost(ctx, "www.noisysockets.github.com")
+	require.NoError(t, err)
+	require.Len(t, addrs, 2)
+
+	require.Equal(t, int32(2), deadQueries.Load())
+}
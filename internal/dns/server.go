@@ -0,0 +1,298 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dns
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	stdnet "net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// recordTTL is the TTL of the records served for peers. Peers can be added
+// and removed at any time, so this is kept short.
+const recordTTL = 60
+
+// ServerConfig is the configuration for a DNS server.
+type ServerConfig struct {
+	// Zone is the domain under which peer names are served.
+	Zone string
+	// LookupPeer returns the addresses of the peer with the given name.
+	LookupPeer func(name string) ([]netip.Addr, bool)
+	// LookupPeerName returns the name of the peer with the given address.
+	LookupPeerName func(addr netip.Addr) (string, bool)
+	// Resolver is used to answer queries for names outside of the zone, so
+	// they benefit from its cache and server selection.
+	Resolver *Resolver
+}
+
+// Server is a DNS server that is authoritative for the names (and reverse
+// addresses) of peers, and forwards all other queries.
+type Server struct {
+	logger    *slog.Logger
+	conf      ServerConfig
+	zone      string
+	udpServer *dns.Server
+	tcpServer *dns.Server
+}
+
+// NewServer creates a new DNS server.
+func NewServer(logger *slog.Logger, conf ServerConfig) *Server {
+	return &Server{
+		logger: logger,
+		conf:   conf,
+		zone:   dns.CanonicalName(conf.Zone),
+	}
+}
+
+// Start starts serving DNS queries received on the given packet connection
+// and listener (which may be nil) in the background.
+func (s *Server) Start(pc stdnet.PacketConn, lis stdnet.Listener) error {
+	s.udpServer = &dns.Server{PacketConn: pc, Handler: s}
+	if err := s.startServer(s.udpServer); err != nil {
+		return err
+	}
+
+	if lis != nil {
+		s.tcpServer = &dns.Server{Listener: lis, Handler: s}
+		if err := s.startServer(s.tcpServer); err != nil {
+			_ = s.udpServer.Shutdown()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) startServer(srv *dns.Server) error {
+	started := make(chan struct{})
+	srv.NotifyStartedFunc = func() { close(started) }
+
+	errCh := make(chan error, 1)
+	go func() {
+		if err := srv.ActivateAndServe(); err != nil {
+			s.logger.Warn("DNS server stopped", "error", err)
+			errCh <- err
+		}
+	}()
+
+	select {
+	case <-started:
+		return nil
+	case err := <-errCh:
+		return err
+	}
+}
+
+// Shutdown stops the server.
+func (s *Server) Shutdown() error {
+	var errs []error
+	if s.udpServer != nil {
+		errs = append(errs, s.udpServer.Shutdown())
+	}
+	if s.tcpServer != nil {
+		errs = append(errs, s.tcpServer.Shutdown())
+	}
+	return errors.Join(errs...)
+}
+
+// ServeDNS implements dns.Handler.
+func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	resp := s.handle(r)
+
+	maxSize := dns.MaxMsgSize
+	if w.LocalAddr().Network() == "udp" {
+		maxSize = dns.MinMsgSize
+		if opt := r.IsEdns0(); opt != nil {
+			maxSize = max(int(opt.UDPSize()), dns.MinMsgSize)
+		}
+	}
+	resp.Truncate(maxSize)
+
+	if err := w.WriteMsg(resp); err != nil {
+		s.logger.Debug("Failed to write DNS response", "error", err)
+	}
+}
+
+func (s *Server) handle(r *dns.Msg) *dns.Msg {
+	if r.Opcode != dns.OpcodeQuery || len(r.Question) != 1 {
+		return new(dns.Msg).SetRcode(r, dns.RcodeNotImplemented)
+	}
+
+	q := r.Question[0]
+	if q.Qclass != dns.ClassINET {
+		return new(dns.Msg).SetRcode(r, dns.RcodeNotImplemented)
+	}
+
+	if q.Qtype == dns.TypePTR {
+		if addr, ok := parseReverseName(q.Name); ok {
+			if name, ok := s.conf.LookupPeerName(addr); ok {
+				resp := new(dns.Msg).SetReply(r)
+				resp.Authoritative = true
+				resp.Answer = append(resp.Answer, &dns.PTR{
+					Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: recordTTL},
+					Ptr: dns.Fqdn(name) + s.zone,
+				})
+				return resp
+			}
+		}
+	}
+
+	if dns.IsSubDomain(s.zone, dns.CanonicalName(q.Name)) {
+		return s.answer(r)
+	}
+
+	return s.forward(r)
+}
+
+// answer responds to a query for a name within the zone.
+func (s *Server) answer(r *dns.Msg) *dns.Msg {
+	q := r.Question[0]
+
+	resp := new(dns.Msg).SetReply(r)
+	resp.Authoritative = true
+
+	if dns.CanonicalName(q.Name) == s.zone {
+		if q.Qtype == dns.TypeSOA {
+			resp.Answer = append(resp.Answer, s.soa())
+		} else {
+			resp.Ns = append(resp.Ns, s.soa())
+		}
+		return resp
+	}
+
+	// Strip the labels of the zone, preserving the case of the peer name.
+	labels := dns.SplitDomainName(q.Name)
+	name := strings.Join(labels[:len(labels)-dns.CountLabel(s.zone)], ".")
+
+	addrs, ok := s.conf.LookupPeer(name)
+	if !ok {
+		resp.Rcode = dns.RcodeNameError
+		resp.Ns = append(resp.Ns, s.soa())
+		return resp
+	}
+
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: recordTTL}
+	for _, addr := range addrs {
+		switch {
+		case q.Qtype == dns.TypeA && addr.Is4():
+			resp.Answer = append(resp.Answer, &dns.A{Hdr: hdr, A: addr.AsSlice()})
+		case q.Qtype == dns.TypeAAAA && addr.Is6():
+			resp.Answer = append(resp.Answer, &dns.AAAA{Hdr: hdr, AAAA: addr.AsSlice()})
+		}
+	}
+
+	if len(resp.Answer) == 0 {
+		resp.Ns = append(resp.Ns, s.soa())
+	}
+
+	return resp
+}
+
+// forward answers a query for a name outside of the zone using the resolver.
+func (s *Server) forward(r *dns.Msg) *dns.Msg {
+	q := r.Question[0]
+
+	upstream, err := s.conf.Resolver.Query(context.Background(), q.Name, q.Qtype)
+	if err != nil {
+		if errors.Is(err, ErrNoServers) {
+			return new(dns.Msg).SetRcode(r, dns.RcodeRefused)
+		}
+
+		s.logger.Debug("Failed to forward DNS query", "name", q.Name, "error", err)
+		return new(dns.Msg).SetRcode(r, dns.RcodeServerFailure)
+	}
+
+	// The response may be shared with the cache, and was sent in reply to the
+	// resolver's query rather than the client's.
+	resp := new(dns.Msg).SetRcode(r, upstream.Rcode)
+	resp.RecursionAvailable = upstream.RecursionAvailable
+	resp.AuthenticatedData = upstream.AuthenticatedData
+	resp.Answer = slices.Clone(upstream.Answer)
+	resp.Ns = slices.Clone(upstream.Ns)
+	for _, rr := range upstream.Extra {
+		// The EDNS options were negotiated with the upstream server.
+		if rr.Header().Rrtype != dns.TypeOPT {
+			resp.Extra = append(resp.Extra, rr)
+		}
+	}
+
+	return resp
+}
+
+// soa returns the start of authority record of the zone, it is included in
+// negative responses so that they can be cached.
+func (s *Server) soa() dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: s.zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: recordTTL},
+		Ns:      "ns." + s.zone,
+		Mbox:    "hostmaster." + s.zone,
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  recordTTL,
+	}
+}
+
+// parseReverseName parses a reverse lookup name (eg. "1.0.0.10.in-addr.arpa.")
+// into an address.
+func parseReverseName(name string) (netip.Addr, bool) {
+	name = dns.CanonicalName(name)
+
+	if rest, ok := strings.CutSuffix(name, ".in-addr.arpa."); ok {
+		labels := strings.Split(rest, ".")
+		if len(labels) != 4 {
+			return netip.Addr{}, false
+		}
+
+		var ip [4]byte
+		for i, label := range labels {
+			octet, err := strconv.ParseUint(label, 10, 8)
+			if err != nil {
+				return netip.Addr{}, false
+			}
+			ip[3-i] = byte(octet)
+		}
+
+		return netip.AddrFrom4(ip), true
+	}
+
+	if rest, ok := strings.CutSuffix(name, ".ip6.arpa."); ok {
+		labels := strings.Split(rest, ".")
+		if len(labels) != 32 {
+			return netip.Addr{}, false
+		}
+
+		var ip [16]byte
+		for i, label := range labels {
+			nibble, err := strconv.ParseUint(label, 16, 4)
+			if err != nil || len(label) != 1 {
+				return netip.Addr{}, false
+			}
+			pos := 31 - i
+			if pos%2 == 0 {
+				ip[pos/2] |= byte(nibble) << 4
+			} else {
+				ip[pos/2] |= byte(nibble)
+			}
+		}
+
+		return netip.AddrFrom16(ip), true
+	}
+
+	return netip.Addr{}, false
+}
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package serveraddr parses the addresses of DNS servers. It has no
+// dependencies so that it can be used to validate configuration.
+package serveraddr
+
+import (
+	"errors"
+	"fmt"
+	stdnet "net"
+	"net/netip"
+	"strings"
+)
+
+// Transport is the transport used to query a DNS server.
+type Transport string
+
+const (
+	// TransportUDP queries the server over UDP, retrying over TCP if the
+	// response is truncated.
+	TransportUDP Transport = "udp"
+	// TransportTCP queries the server over TCP only.
+	TransportTCP Transport = "tcp"
+)
+
+// Addr is the address of a DNS server.
+type Addr struct {
+	netip.AddrPort
+	// Transport is the transport used to query the server, defaults to UDP.
+	Transport Transport
+}
+
+func (a Addr) String() string {
+	if a.Transport == "" {
+		return a.AddrPort.String()
+	}
+	return string(a.Transport) + "://" + a.AddrPort.String()
+}
+
+// Parse parses a DNS server address of the form "[udp://|tcp://]ip[:port]".
+// If no port is specified, it is left as zero.
+func Parse(s string) (Addr, error) {
+	var serverAddr Addr
+
+	if scheme, rest, ok := strings.Cut(s, "://"); ok {
+		switch Transport(scheme) {
+		case TransportUDP, TransportTCP:
+			serverAddr.Transport = Transport(scheme)
+		default:
+			return Addr{}, fmt.Errorf("unsupported transport: %s", scheme)
+		}
+		s = rest
+	}
+
+	// Do we have a port specified?
+	if _, _, err := stdnet.SplitHostPort(s); err == nil {
+		addrPort, err := netip.ParseAddrPort(s)
+		if err != nil {
+			return Addr{}, err
+		}
+		if addrPort.Port() == 0 {
+			return Addr{}, errors.New("port must be between 1 and 65535")
+		}
+		serverAddr.AddrPort = addrPort
+	} else {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return Addr{}, err
+		}
+		serverAddr.AddrPort = netip.AddrPortFrom(addr, 0)
+	}
+
+	return serverAddr, nil
+}
@@ -0,0 +1,33 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package serveraddr
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	addr, err := Parse("10.7.0.1")
+	require.NoError(t, err)
+	require.Equal(t, Addr{AddrPort: netip.MustParseAddrPort("10.7.0.1:0")}, addr)
+
+	addr, err = Parse("tcp://[fd00::1]:5353")
+	require.NoError(t, err)
+	require.Equal(t, Addr{AddrPort: netip.MustParseAddrPort("[fd00::1]:5353"), Transport: TransportTCP}, addr)
+	require.Equal(t, "tcp://[fd00::1]:5353", addr.String())
+
+	for _, s := range []string{"10.7.0.1:0", "quic://10.7.0.1", "example.com"} {
+		_, err := Parse(s)
+		require.Error(t, err, s)
+	}
+}
@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dns
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/noisysockets/internal/dns/serveraddr"
+)
+
+// SystemDNSServers returns the DNS servers configured in the hosts
+// /etc/resolv.conf file.
+func SystemDNSServers() ([]serveraddr.Addr, error) {
+	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return nil, fmt.Errorf("could not read resolv.conf: %w", err)
+	}
+
+	port, err := strconv.ParseUint(conf.Port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse DNS port: %w", err)
+	}
+
+	var dnsServers []serveraddr.Addr
+	for _, server := range conf.Servers {
+		addr, err := netip.ParseAddr(server)
+		if err != nil {
+			// Skip anything that is not an IP address.
+			continue
+		}
+
+		dnsServers = append(dnsServers, serveraddr.Addr{AddrPort: netip.AddrPortFrom(addr, uint16(port))})
+	}
+
+	return dnsServers, nil
+}
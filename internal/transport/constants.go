@@ -64,3 +64,8 @@ const (
 	UnderLoadAfterTime = time.Second // how long does the transport remain under load after detected
 	MaxPeers           = 1 << 16     // maximum number of configured peers
 )
+
+const (
+	AdaptiveKeepaliveMax        = time.Second * 45 // maximum interval an adaptive keepalive will be stretched to
+	AdaptiveKeepaliveGrowthRate = 4                // adaptive keepalive interval grows by 1/AdaptiveKeepaliveGrowthRate per step
+)
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package transport
+
+import (
+	"time"
+
+	"github.com/noisysockets/noisysockets/internal/conn"
+	"github.com/noisysockets/noisysockets/types"
+)
+
+// EventType is the type of a peer event.
+type EventType int
+
+const (
+	// EventEndpointChanged is emitted when the endpoint of a peer is changed
+	// locally, eg. by configuration or re-resolution of its hostname.
+	EventEndpointChanged EventType = iota
+	// EventRoamed is emitted when a peer is observed sending from a new endpoint.
+	EventRoamed
+	// EventHandshakeCompleted is emitted when a handshake with a peer completes.
+	EventHandshakeCompleted
+	// EventHandshakeFailed is emitted when a handshake with a peer is given up
+	// on after MaxTimerHandshakes attempts.
+	EventHandshakeFailed
+	// EventKeypairExpired is emitted when all keys for a peer have been
+	// zeroed, because no new keypair was negotiated in time.
+	EventKeypairExpired
+)
+
+// Event is a notable change in the state of a peer.
+type Event struct {
+	// Type is the type of event.
+	Type EventType
+	// PublicKey is the public key of the peer.
+	PublicKey types.NoisePublicKey
+	// Endpoint is the new endpoint of the peer (for EventEndpointChanged and EventRoamed).
+	Endpoint conn.Endpoint
+	// Time is when the event occurred.
+	Time time.Time
+}
+
+// EventHandler is called synchronously for every event, it must not block.
+type EventHandler func(Event)
+
+// SetEventHandler sets the handler that is called for every event, a nil
+// handler disables events.
+func (transport *Transport) SetEventHandler(handler EventHandler) {
+	transport.eventHandler.Store(&handler)
+}
+
+func (transport *Transport) emitEvent(event Event) {
+	handler := transport.eventHandler.Load()
+	if handler == nil || *handler == nil {
+		return
+	}
+
+	event.Time = time.Now()
+	(*handler)(event)
+}
+
+func endpointsEqual(a, b conn.Endpoint) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	// Fast path to avoid allocating strings on the hot path.
+	if a, ok := a.(*conn.StdNetEndpoint); ok {
+		if b, ok := b.(*conn.StdNetEndpoint); ok {
+			return a.AddrPort == b.AddrPort
+		}
+	}
+
+	return a.DstToString() == b.DstToString()
+}
@@ -57,6 +57,8 @@ type Peer struct {
 	endpoint struct {
 		sync.Mutex
 		val conn.Endpoint
+		// candidates are the resolved addresses of the configured endpoint.
+		candidates []conn.Endpoint
 	}
 
 	timers struct {
@@ -81,7 +83,13 @@ type Peer struct {
 	}
 
 	cookieGenerator   CookieGenerator
-	keepAliveInterval atomic.Uint32
+	keepAliveInterval atomic.Uint32 // current keepalive interval in seconds
+
+	adaptiveKeepAlive struct {
+		enabled      atomic.Bool
+		baseInterval atomic.Uint32 // configured keepalive interval in seconds
+		lastSentNano atomic.Int64  // nano seconds since epoch of the last packet sent
+	}
 }
 
 func (transport *Transport) NewPeer(pk types.NoisePublicKey) (*Peer, error) {
@@ -285,10 +293,227 @@ func (peer *Peer) GetEndpoint() conn.Endpoint {
 
 func (peer *Peer) SetEndpoint(endpoint conn.Endpoint) {
 	peer.endpoint.Lock()
-	defer peer.endpoint.Unlock()
+	changed := peer.setEndpointLocked(endpoint)
+	peer.endpoint.Unlock()
+
+	if changed {
+		peer.emitEndpointEvent(EventEndpointChanged, endpoint)
+	}
+}
+
+// roamEndpoint updates the endpoint of the peer to the source address of an
+// authenticated packet received from it.
+func (peer *Peer) roamEndpoint(endpoint conn.Endpoint) {
+	peer.endpoint.Lock()
+	changed := peer.setEndpointLocked(endpoint)
+	peer.endpoint.Unlock()
+
+	if changed {
+		peer.transport.log.Debug("Peer roamed to new endpoint",
+			"peer", peer, "endpoint", endpoint.DstToString())
+
+		peer.emitEndpointEvent(EventRoamed, endpoint)
+	}
+}
+
+// SetEndpointCandidates sets the resolved addresses of the peer's configured
+// endpoint. If the current endpoint was not learned from the peer (roaming),
+// and is no longer a candidate, the peer is switched to the first candidate.
+// When a handshake times out, the peer will fall back to the next candidate.
+func (peer *Peer) SetEndpointCandidates(candidates []conn.Endpoint) {
+	peer.endpoint.Lock()
+
+	var changed bool
+	if len(candidates) > 0 && (peer.endpoint.val == nil || peer.endpointCandidateIndexLocked() >= 0) {
+		peer.endpoint.candidates = candidates
+		if peer.endpointCandidateIndexLocked() < 0 {
+			changed = peer.setEndpointLocked(candidates[0])
+		}
+	} else {
+		peer.endpoint.candidates = candidates
+	}
+
+	endpoint := peer.endpoint.val
+	peer.endpoint.Unlock()
+
+	if changed {
+		peer.emitEndpointEvent(EventEndpointChanged, endpoint)
+	}
+}
+
+// nextEndpointCandidate switches the peer to the next candidate endpoint, this
+// is used to fall back across all resolved addresses of the configured
+// endpoint when handshakes are failing.
+func (peer *Peer) nextEndpointCandidate() {
+	peer.endpoint.Lock()
+
+	candidates := peer.endpoint.candidates
+	if len(candidates) == 0 {
+		peer.endpoint.Unlock()
+		return
+	}
+
+	// If the peer has roamed, try the configured endpoint again.
+	next := candidates[(peer.endpointCandidateIndexLocked()+1)%len(candidates)]
+	changed := peer.setEndpointLocked(next)
+	peer.endpoint.Unlock()
+
+	if changed {
+		peer.transport.log.Debug("Falling back to alternative endpoint",
+			"peer", peer, "endpoint", next.DstToString())
+
+		peer.emitEndpointEvent(EventEndpointChanged, next)
+	}
+}
+
+func (peer *Peer) endpointCandidateIndexLocked() int {
+	for i, candidate := range peer.endpoint.candidates {
+		if endpointsEqual(candidate, peer.endpoint.val) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (peer *Peer) setEndpointLocked(endpoint conn.Endpoint) bool {
+	if endpointsEqual(peer.endpoint.val, endpoint) {
+		// Keep the most recent endpoint, it may carry a different source address.
+		peer.endpoint.val = endpoint
+		return false
+	}
+
+	// A change of endpoint suggests the NAT mapping has expired.
+	if peer.adaptiveKeepAlive.enabled.Load() && peer.endpoint.val != nil {
+		peer.resetAdaptiveKeepAlive()
+	}
+
 	peer.endpoint.val = endpoint
+	return endpoint != nil
+}
+
+func (peer *Peer) emitEndpointEvent(eventType EventType, endpoint conn.Endpoint) {
+	peer.transport.emitEvent(Event{
+		Type:      eventType,
+		PublicKey: peer.pk,
+		Endpoint:  endpoint,
+	})
 }
 
+func (peer *Peer) emitEvent(eventType EventType) {
+	peer.transport.emitEvent(Event{
+		Type:      eventType,
+		PublicKey: peer.pk,
+	})
+}
+
+// SetKeepAliveInterval sets the interval at which keepalives are sent to the
+// peer, an interval of zero disables keepalives.
 func (peer *Peer) SetKeepAliveInterval(interval time.Duration) {
+	peer.adaptiveKeepAlive.baseInterval.Store(uint32(interval.Seconds()))
 	peer.keepAliveInterval.Store(uint32(interval.Seconds()))
 }
+
+// SetAdaptiveKeepAlive enables or disables the adaptive keepalive mode.
+// In adaptive mode the keepalive interval is gradually stretched (up to
+// AdaptiveKeepaliveMax) when packets from the peer arrive after nothing has
+// been sent to it for longer than the interval, which shows the NAT mapping
+// survives that long. Our own traffic refreshes the mapping, so handshakes we
+// initiate are no evidence either way. The interval is reset to the
+// configured interval when a handshake has to be retransmitted or the
+// endpoint of the peer changes.
+func (peer *Peer) SetAdaptiveKeepAlive(enabled bool) {
+	peer.adaptiveKeepAlive.enabled.Store(enabled)
+	if !enabled {
+		peer.resetAdaptiveKeepAlive()
+	}
+}
+
+// KeepAliveInterval returns the current keepalive interval, this may differ
+// from the configured interval in adaptive mode.
+func (peer *Peer) KeepAliveInterval() time.Duration {
+	return time.Duration(peer.keepAliveInterval.Load()) * time.Second
+}
+
+// adaptKeepAlive is called when a packet is received from the peer. If
+// nothing has been sent to the peer for longer than the keepalive interval,
+// the interval is stretched, but never beyond the observed idle time.
+func (peer *Peer) adaptKeepAlive(now time.Time) {
+	lastSent := peer.adaptiveKeepAlive.lastSentNano.Load()
+	if lastSent == 0 {
+		return
+	}
+
+	idle := now.Sub(time.Unix(0, lastSent))
+	if idle <= peer.KeepAliveInterval() {
+		return
+	}
+
+	peer.stretchAdaptiveKeepAlive(uint32(min(idle, AdaptiveKeepaliveMax).Seconds()))
+}
+
+// stretchAdaptiveKeepAlive grows the keepalive interval by a step, up to
+// limit seconds.
+func (peer *Peer) stretchAdaptiveKeepAlive(limit uint32) {
+	interval := peer.keepAliveInterval.Load()
+	if interval == 0 || interval >= limit {
+		return
+	}
+
+	interval = min(interval+max(interval/AdaptiveKeepaliveGrowthRate, 1), limit)
+
+	peer.keepAliveInterval.Store(interval)
+}
+
+func (peer *Peer) resetAdaptiveKeepAlive() {
+	peer.keepAliveInterval.Store(peer.adaptiveKeepAlive.baseInterval.Load())
+}
+
+// SetPresharedKey sets the optional preshared key that is mixed into the
+// handshake, a zero key disables the use of a preshared key.
+func (peer *Peer) SetPresharedKey(psk types.NoisePresharedKey) {
+	peer.handshake.mutex.Lock()
+	defer peer.handshake.mutex.Unlock()
+	peer.handshake.presharedKey = psk
+}
+
+// TxBytes returns the number of bytes sent to the peer.
+func (peer *Peer) TxBytes() uint64 {
+	return peer.txBytes.Load()
+}
+
+// RxBytes returns the number of bytes received from the peer.
+func (peer *Peer) RxBytes() uint64 {
+	return peer.rxBytes.Load()
+}
+
+// LastHandshake returns the time of the last successful handshake with the peer.
+// If no handshake has been completed, the zero time is returned.
+func (peer *Peer) LastHandshake() time.Time {
+	nano := peer.lastHandshakeNano.Load()
+	if nano == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nano)
+}
+
+// HandshakeAttempts returns the number of handshake retransmission attempts
+// since the last successful handshake.
+func (peer *Peer) HandshakeAttempts() uint32 {
+	return peer.timers.handshakeAttempts.Load()
+}
+
+// CurrentKeypairCreated returns the creation time of the current keypair,
+// and whether the keypair is still valid for sending.
+func (peer *Peer) CurrentKeypairCreated() (time.Time, bool) {
+	keypairs := &peer.keypairs
+	keypairs.RLock()
+	defer keypairs.RUnlock()
+
+	keypair := keypairs.current
+	if keypair == nil {
+		return time.Time{}, false
+	}
+
+	valid := keypair.sendNonce.Load() < RejectAfterMessages && time.Since(keypair.created) < RejectAfterTime
+	return keypair.created, valid
+}
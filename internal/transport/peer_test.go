@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package transport
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/neilotoole/slogt"
+	"github.com/noisysockets/noisysockets/internal/conn"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveKeepAlive(t *testing.T) {
+	peer := &Peer{transport: &Transport{}}
+	peer.SetKeepAliveInterval(25 * time.Second)
+	peer.SetAdaptiveKeepAlive(true)
+
+	now := time.Now()
+	lastSent := func(ago time.Duration) {
+		peer.adaptiveKeepAlive.lastSentNano.Store(now.Add(-ago).UnixNano())
+	}
+
+	// The NAT mapping of an initiator expiring between keepalives. Packets
+	// from the peer are dropped, but the handshakes we initiate still complete
+	// at the first attempt as they create a new mapping.
+	for i := 0; i < 10; i++ {
+		peer.timersHandshakeComplete()
+	}
+	require.Equal(t, 25*time.Second, peer.KeepAliveInterval())
+
+	// Traffic from the peer shortly after we sent something proves nothing.
+	lastSent(time.Second)
+	peer.adaptKeepAlive(now)
+	require.Equal(t, 25*time.Second, peer.KeepAliveInterval())
+
+	// But traffic from the peer after a longer idle period does.
+	lastSent(40 * time.Second)
+	peer.adaptKeepAlive(now)
+	require.Equal(t, 31*time.Second, peer.KeepAliveInterval())
+
+	// The interval is never stretched beyond the observed idle period.
+	lastSent(33 * time.Second)
+	peer.adaptKeepAlive(now)
+	require.Equal(t, 33*time.Second, peer.KeepAliveInterval())
+
+	for i := 0; i < 10; i++ {
+		lastSent(time.Hour)
+		peer.adaptKeepAlive(now)
+	}
+	require.Equal(t, AdaptiveKeepaliveMax, peer.KeepAliveInterval())
+
+	// An unchanged endpoint should not reset the interval.
+	peer.SetEndpoint(&conn.StdNetEndpoint{AddrPort: netip.MustParseAddrPort("127.0.0.1:1234")})
+	peer.SetEndpoint(&conn.StdNetEndpoint{AddrPort: netip.MustParseAddrPort("127.0.0.1:1234")})
+	require.Equal(t, AdaptiveKeepaliveMax, peer.KeepAliveInterval())
+
+	// A roaming peer suggests the NAT mapping has expired.
+	peer.SetEndpoint(&conn.StdNetEndpoint{AddrPort: netip.MustParseAddrPort("127.0.0.1:4321")})
+	require.Equal(t, 25*time.Second, peer.KeepAliveInterval())
+
+	// Keepalives that are disabled should never be stretched.
+	peer.SetKeepAliveInterval(0)
+	lastSent(time.Hour)
+	peer.adaptKeepAlive(now)
+	require.Zero(t, peer.KeepAliveInterval())
+}
+
+func TestEndpointCandidates(t *testing.T) {
+	var events []Event
+
+	transport := &Transport{log: slogt.New(t)}
+	transport.SetEventHandler(func(event Event) {
+		events = append(events, event)
+	})
+
+	peer := &Peer{transport: transport}
+
+	first := &conn.StdNetEndpoint{AddrPort: netip.MustParseAddrPort("192.0.2.1:51820")}
+	second := &conn.StdNetEndpoint{AddrPort: netip.MustParseAddrPort("192.0.2.2:51820")}
+
+	peer.SetEndpointCandidates([]conn.Endpoint{first, second})
+	require.Equal(t, first, peer.GetEndpoint())
+
+	// Fall back to the next candidate when handshakes are failing.
+	peer.nextEndpointCandidate()
+	require.Equal(t, second, peer.GetEndpoint())
+
+	peer.nextEndpointCandidate()
+	require.Equal(t, first, peer.GetEndpoint())
+
+	// A roamed endpoint should not be replaced by a re-resolution.
+	roamed := &conn.StdNetEndpoint{AddrPort: netip.MustParseAddrPort("198.51.100.1:4321")}
+	peer.SetEndpoint(roamed)
+	peer.SetEndpointCandidates([]conn.Endpoint{second})
+	require.Equal(t, roamed, peer.GetEndpoint())
+
+	// But the configured endpoint is tried again if handshakes are failing.
+	peer.nextEndpointCandidate()
+	require.Equal(t, second, peer.GetEndpoint())
+
+	var endpoints []string
+	for _, event := range events {
+		require.Equal(t, EventEndpointChanged, event.Type)
+		endpoints = append(endpoints, event.Endpoint.DstToString())
+	}
+
+	require.Equal(t, []string{
+		"192.0.2.1:51820",
+		"192.0.2.2:51820",
+		"192.0.2.1:51820",
+		"198.51.100.1:4321",
+		"192.0.2.2:51820",
+	}, endpoints)
+}
@@ -402,7 +402,7 @@ func (transport *Transport) RoutineHandshake(id int) {
 			peer.timersAnyAuthenticatedPacketReceived()
 
 			// update endpoint
-			peer.SetEndpoint(elem.endpoint)
+			peer.roamEndpoint(elem.endpoint)
 
 			transport.log.Debug("Received handshake initiation", "peer", peer)
 			peer.rxBytes.Add(uint64(len(elem.packet)))
@@ -433,7 +433,7 @@ func (transport *Transport) RoutineHandshake(id int) {
 			}
 
 			// update endpoint
-			peer.SetEndpoint(elem.endpoint)
+			peer.roamEndpoint(elem.endpoint)
 
 			transport.log.Debug("Received handshake response", "peer", peer)
 			peer.rxBytes.Add(uint64(len(elem.packet)))
@@ -496,7 +496,7 @@ func (peer *Peer) RoutineSequentialReceiver(maxBatchSize int) {
 
 			validTailPacket = i
 			if peer.ReceivedWithKeypair(elem.keypair) {
-				peer.SetEndpoint(elem.endpoint)
+				peer.roamEndpoint(elem.endpoint)
 				peer.timersHandshakeComplete()
 				if err := peer.SendStagedPackets(); err != nil {
 					t.log.Warn("Failed to send staged packets", "peer", peer, "error", err)
@@ -516,7 +516,7 @@ func (peer *Peer) RoutineSequentialReceiver(maxBatchSize int) {
 
 		peer.rxBytes.Add(rxBytesLen)
 		if validTailPacket >= 0 {
-			peer.SetEndpoint(elemsContainer.elems[validTailPacket].endpoint)
+			peer.roamEndpoint(elemsContainer.elems[validTailPacket].endpoint)
 			if err := peer.keepKeyFreshReceiving(); err != nil {
 				t.log.Warn("Failed to keep key fresh", "peer", peer, "error", err)
 				continue
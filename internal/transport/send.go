@@ -489,7 +489,7 @@ func (transport *Transport) RoutineEncryption(id int) {
 			binary.LittleEndian.PutUint64(fieldNonce, elem.nonce)
 
 			// pad content to multiple of 16
-			paddingSize := calculatePaddingSize(len(elem.packet), DefaultMTU)
+			paddingSize := calculatePaddingSize(len(elem.packet), int(transport.mtu.Load()))
 			elem.packet = append(elem.packet, paddingZeros[:paddingSize]...)
 
 			// encrypt content and release to consumer
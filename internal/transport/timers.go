@@ -120,11 +120,21 @@ func expiredRetransmitHandshake(peer *Peer) {
 		if peer.timersActive() && !peer.timers.zeroKeyMaterial.IsPending() {
 			peer.timers.zeroKeyMaterial.Mod(RejectAfterTime * 3)
 		}
+
+		peer.emitEvent(EventHandshakeFailed)
 	} else {
+		// The NAT mapping may have expired, fall back to the configured interval.
+		if peer.adaptiveKeepAlive.enabled.Load() {
+			peer.resetAdaptiveKeepAlive()
+		}
+
 		peer.timers.handshakeAttempts.Add(1)
 		peer.transport.log.Warn("Handshake did not complete within timeout, retrying",
 			"peer", peer, "timeout", int(RekeyTimeout.Seconds()), "try", peer.timers.handshakeAttempts.Load()+1)
 
+		// The endpoint may be unreachable, try another address.
+		peer.nextEndpointCandidate()
+
 		if err := peer.SendHandshakeInitiation(true); err != nil {
 			peer.transport.log.Error("Failed to retransmit handshake initiation",
 				"peer", peer, "error", err)
@@ -159,6 +169,8 @@ func expiredZeroKeyMaterial(peer *Peer) {
 	peer.transport.log.Debug("Removing all keys, since we haven't received a new one in time",
 		"peer", peer, "timeout", int((RejectAfterTime * 3).Seconds()))
 	peer.ZeroAndFlushAll()
+
+	peer.emitEvent(EventKeypairExpired)
 }
 
 func expiredKeepAlive(peer *Peer) {
@@ -193,6 +205,9 @@ func (peer *Peer) timersAnyAuthenticatedPacketSent() {
 	if peer.timersActive() {
 		peer.timers.sendKeepalive.Del()
 	}
+	if peer.adaptiveKeepAlive.enabled.Load() {
+		peer.adaptiveKeepAlive.lastSentNano.Store(time.Now().UnixNano())
+	}
 }
 
 /* Should be called after any type of authenticated packet is received -- keepalive, data, or handshake. */
@@ -200,6 +215,9 @@ func (peer *Peer) timersAnyAuthenticatedPacketReceived() {
 	if peer.timersActive() {
 		peer.timers.newHandshake.Del()
 	}
+	if peer.adaptiveKeepAlive.enabled.Load() {
+		peer.adaptKeepAlive(time.Now())
+	}
 }
 
 /* Should be called after a handshake initiation message is sent. */
@@ -217,6 +235,8 @@ func (peer *Peer) timersHandshakeComplete() {
 	peer.timers.handshakeAttempts.Store(0)
 	peer.timers.sentLastMinuteHandshake.Store(false)
 	peer.lastHandshakeNano.Store(time.Now().UnixNano())
+
+	peer.emitEvent(EventHandshakeCompleted)
 }
 
 /* Should be called after an ephemeral key is created, which is before sending a handshake response or after receiving a handshake response. */
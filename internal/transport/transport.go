@@ -105,6 +105,9 @@ type Transport struct {
 	}
 
 	sourceSink SourceSink
+	mtu        atomic.Int32 // MTU of the source sink, used for padding
+
+	eventHandler atomic.Pointer[EventHandler]
 
 	closed chan struct{}
 	log    *slog.Logger
@@ -313,6 +316,7 @@ func NewTransport(sourceSink SourceSink, bind conn.Bind, logger *slog.Logger) *T
 	t.log = logger
 	t.net.bind = bind
 	t.sourceSink = sourceSink
+	t.mtu.Store(DefaultMTU)
 	t.peers.keyMap = make(map[types.NoisePublicKey]*Peer)
 	t.rate.limiter.Init()
 	t.indexTable.Init()
@@ -516,6 +520,17 @@ func (transport *Transport) BindClose() error {
 	return err
 }
 
+// SetMTU sets the MTU of the source sink, this is used to calculate the
+// padding of outbound packets.
+func (transport *Transport) SetMTU(mtu int) {
+	transport.mtu.Store(int32(mtu))
+}
+
+// MTU returns the MTU of the source sink.
+func (transport *Transport) MTU() int {
+	return int(transport.mtu.Load())
+}
+
 func (transport *Transport) UpdatePort(port uint16) error {
 	transport.net.Lock()
 	transport.net.port = port
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package noisysockets
+
+import (
+	"fmt"
+
+	"github.com/noisysockets/noisysockets/config/v1alpha1"
+	"github.com/noisysockets/noisysockets/internal/dns"
+)
+
+// defaultNameServerZone is the zone peer names are served under, if not
+// otherwise specified. The .internal TLD is reserved for private use.
+const defaultNameServerZone = "internal"
+
+// startNameServerLocked starts the embedded DNS server, on port 53 of all
+// local addresses.
+func (net *NoisySocketsNetwork) startNameServerLocked(conf v1alpha1.NameServerConfig) error {
+	zone := conf.Zone
+	if zone == "" {
+		zone = defaultNameServerZone
+	}
+
+	pc, err := net.ListenPacket("udp", ":53")
+	if err != nil {
+		return fmt.Errorf("failed to listen on udp port 53: %w", err)
+	}
+
+	lis, err := net.Listen("tcp", ":53")
+	if err != nil {
+		_ = pc.Close()
+		return fmt.Errorf("failed to listen on tcp port 53: %w", err)
+	}
+
+	srv := dns.NewServer(net.logger, dns.ServerConfig{
+		Zone:           zone,
+		LookupPeer:     net.pd.LookupPeerAddressesByName,
+		LookupPeerName: net.pd.LookupPeerNameByAddress,
+		Resolver:       net.resolver,
+	})
+
+	if err := srv.Start(pc, lis); err != nil {
+		_ = pc.Close()
+		_ = lis.Close()
+		return fmt.Errorf("failed to start name server: %w", err)
+	}
+
+	net.nameServer = srv
+	net.nameServerConf = &conf
+
+	return nil
+}
+
+func (net *NoisySocketsNetwork) stopNameServerLocked() {
+	if net.nameServer == nil {
+		return
+	}
+
+	if err := net.nameServer.Shutdown(); err != nil {
+		net.logger.Warn("Failed to shut down name server", "error", err)
+	}
+
+	net.nameServer = nil
+	net.nameServerConf = nil
+}
+
+// reconcileNameServerLocked starts, stops or restarts the embedded DNS
+// server to match the given configuration.
+func (net *NoisySocketsNetwork) reconcileNameServerLocked(conf *v1alpha1.NameServerConfig) error {
+	if conf != nil && net.nameServerConf != nil && *conf == *net.nameServerConf {
+		return nil
+	}
+
+	net.stopNameServerLocked()
+
+	if conf == nil {
+		return nil
+	}
+
+	return net.startNameServerLocked(*conf)
+}
@@ -35,31 +35,36 @@ import (
 	"fmt"
 	"log/slog"
 	"net/netip"
+	"os"
 	"strconv"
+	"syscall"
 
 	"context"
 	"errors"
 	"regexp"
+	"slices"
+	"sync"
 	"time"
 
 	stdnet "net"
 
+	"github.com/noisysockets/noisysockets/config"
 	"github.com/noisysockets/noisysockets/config/v1alpha1"
 	"github.com/noisysockets/noisysockets/internal/conn"
 	"github.com/noisysockets/noisysockets/internal/dns"
-	"github.com/noisysockets/noisysockets/internal/dns/addrselect"
+	"github.com/noisysockets/noisysockets/internal/dns/serveraddr"
 	"github.com/noisysockets/noisysockets/internal/transport"
 	"github.com/noisysockets/noisysockets/network"
 	"github.com/noisysockets/noisysockets/types"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
-	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/icmp"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
 )
 
 var (
@@ -70,23 +75,50 @@ var (
 	ErrMissingAddress    = errors.New("missing address")
 	ErrNoEndpoint        = errors.New("no known endpoint for peer")
 	ErrUnknownPeer       = errors.New("unknown peer")
+	ErrPeerExists        = errors.New("peer already exists")
+	ErrUnsupportedChange = errors.New("change requires recreating the network")
 )
 
+var (
+	_ network.Network = (*NoisySocketsNetwork)(nil)
+)
+
+// listenBacklog is the maximum number of pending connections for listeners.
+const listenBacklog = 1024
+
 var protoSplitter = regexp.MustCompile(`^(tcp|udp)(4|6)?$`)
 
 type NoisySocketsNetwork struct {
+	logger       *slog.Logger
 	transport    *transport.Transport
 	pd           *peerDirectory
+	acl          *accessControl
 	stack        *stack.Stack
 	localAddrs   []netip.Addr
-	dnsServers   []netip.AddrPort
 	hasV4, hasV6 bool
+	resolver     *dns.Resolver
+	// peersMu serializes peer management operations and reconfiguration.
+	peersMu           sync.Mutex
+	name              string
+	privateKey        types.NoisePrivateKey
+	listenPort        uint16
+	endpointResolvers map[types.NoisePublicKey]*endpointResolver
+	subscribers       eventSubscribers
+	nameServer        *dns.Server
+	nameServerConf    *v1alpha1.NameServerConfig
+	// portForwardsMu protects portForwards.
+	portForwardsMu sync.Mutex
+	portForwards   map[string]*portForward
 }
 
 // NewNetwork creates a new network using the provided configuration.
 // The returned network is a userspace WireGuard peer that exposes
 // Dial() and Listen() methods compatible with the net package.
-func NewNetwork(logger *slog.Logger, conf *v1alpha1.Config) (network.Network, error) {
+func NewNetwork(logger *slog.Logger, conf *v1alpha1.Config) (*NoisySocketsNetwork, error) {
+	if err := config.Validate(conf); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
 	var privateKey types.NoisePrivateKey
 	if err := privateKey.FromString(conf.PrivateKey); err != nil {
 		return nil, fmt.Errorf("failed to parse private key: %w", err)
@@ -103,53 +135,19 @@ func NewNetwork(logger *slog.Logger, conf *v1alpha1.Config) (network.Network, er
 
 	pd := newPeerDirectory()
 
-	// Add the local node to the peer directory.
-	if err := pd.AddPeer(conf.Name, privateKey.PublicKey(), localAddrs); err != nil {
-		return nil, fmt.Errorf("could not add local peer to directory: %w", err)
+	var localPrefixes []netip.Prefix
+	for _, addr := range localAddrs {
+		localPrefixes = append(localPrefixes, netip.PrefixFrom(addr, addr.BitLen()))
 	}
 
-	var defaultGateway *types.NoisePublicKey
-	var defaultGatewayAddrs []netip.Addr
-	for _, peerConf := range conf.Peers {
-		if peerConf.DefaultGateway {
-			defaultGateway = new(types.NoisePublicKey)
-			if err := defaultGateway.FromString(peerConf.PublicKey); err != nil {
-				return nil, fmt.Errorf("could not parse default gateway public key: %w", err)
-			}
-
-			for _, ip := range peerConf.IPs {
-				addr, err := netip.ParseAddr(ip)
-				if err != nil {
-					return nil, fmt.Errorf("could not parse default gateway address: %w", err)
-				}
-
-				defaultGatewayAddrs = append(defaultGatewayAddrs, addr)
-			}
-
-			break
-		}
+	// Add the local node to the peer directory.
+	if err := pd.AddPeer(conf.Name, privateKey.PublicKey(), localPrefixes); err != nil {
+		return nil, fmt.Errorf("could not add local peer to directory: %w", err)
 	}
 
-	var dnsServers []netip.AddrPort
-	for _, addr := range conf.DNSServers {
-		var dnsServer netip.AddrPort
-
-		// Do we have a port specified?
-		if _, _, err := stdnet.SplitHostPort(addr); err == nil {
-			dnsServer, err = netip.ParseAddrPort(addr)
-			if err != nil {
-				return nil, fmt.Errorf("could not parse DNS server address: %w", err)
-			}
-		} else {
-			addr, err := netip.ParseAddr(addr)
-			if err != nil {
-				return nil, fmt.Errorf("could not parse DNS server address: %w", err)
-			}
-
-			dnsServer = netip.AddrPortFrom(addr, 0)
-		}
-
-		dnsServers = append(dnsServers, dnsServer)
+	dnsServers, err := parseDNSServers(conf.DNSServers)
+	if err != nil {
+		return nil, err
 	}
 
 	s := stack.New(stack.Options{
@@ -158,7 +156,21 @@ func NewNetwork(logger *slog.Logger, conf *v1alpha1.Config) (network.Network, er
 		HandleLocal:        true,
 	})
 
-	sourceSink, err := newSourceSink(logger, pd, s, defaultGateway)
+	mtu := conf.MTU
+	if mtu == 0 {
+		mtu = transport.DefaultMTU
+	}
+
+	acl := newAccessControl(logger, pd)
+
+	aclPolicy, err := parseACL(conf.ACL)
+	if err != nil {
+		return nil, err
+	}
+	acl.SetPolicy(aclPolicy)
+
+	// The netstack derives the TCP MSS from the MTU of the NIC.
+	sourceSink, err := newSourceSink(logger, pd, acl, s, mtu)
 	if err != nil {
 		return nil, fmt.Errorf("could not create source sink: %w", err)
 	}
@@ -186,120 +198,90 @@ func NewNetwork(logger *slog.Logger, conf *v1alpha1.Config) (network.Network, er
 			hasV6 = true
 		}
 	}
-	if hasV4 {
-		var gatewayV4 tcpip.Address
-		if defaultGateway != nil {
-			for _, addr := range defaultGatewayAddrs {
-				if addr.Is4() {
-					gatewayV4 = tcpip.AddrFromSlice(addr.AsSlice())
-					break
-				}
-			}
-		}
 
-		s.AddRoute(tcpip.Route{
-			Destination: header.IPv4EmptySubnet,
-			NIC:         1,
-			Gateway:     gatewayV4,
-		})
-	}
-	if hasV6 {
-		var gatewayV6 tcpip.Address
-		if defaultGateway != nil {
-			for _, addr := range defaultGatewayAddrs {
-				if addr.Is6() {
-					gatewayV6 = tcpip.AddrFromSlice(addr.AsSlice())
-					break
-				}
-			}
-		}
+	t := transport.NewTransport(sourceSink, conn.NewStdNetBind(), logger)
 
-		s.AddRoute(tcpip.Route{
-			Destination: header.IPv6EmptySubnet,
-			NIC:         1,
-			Gateway:     gatewayV6,
-		})
+	t.SetPrivateKey(privateKey)
+	t.SetMTU(mtu)
+
+	net := &NoisySocketsNetwork{
+		logger:            logger,
+		transport:         t,
+		pd:                pd,
+		acl:               acl,
+		stack:             s,
+		localAddrs:        localAddrs,
+		hasV4:             hasV4,
+		hasV6:             hasV6,
+		name:              conf.Name,
+		privateKey:        privateKey,
+		listenPort:        conf.ListenPort,
+		endpointResolvers: make(map[types.NoisePublicKey]*endpointResolver),
+		portForwards:      make(map[string]*portForward),
 	}
 
-	t := transport.NewTransport(sourceSink, conn.NewStdNetBind(), logger)
+	net.resolver = dns.NewResolver(net, dns.NewCache(dns.DefaultCacheSize), dns.ResolverConfig{
+		Servers: dnsServers,
+		Timeout: conf.DNSTimeout,
+	})
 
-	t.SetPrivateKey(privateKey)
+	t.SetEventHandler(net.handleTransportEvent)
+
+	net.updateRoutes()
 
 	if err := t.UpdatePort(conf.ListenPort); err != nil {
+		_ = net.Close()
 		return nil, fmt.Errorf("failed to update port: %w", err)
 	}
 
-	for _, peerConf := range conf.Peers {
-		var peerPublicKey types.NoisePublicKey
-		if err := peerPublicKey.FromString(peerConf.PublicKey); err != nil {
-			return nil, fmt.Errorf("failed to parse peer public key: %w", err)
-		}
-
-		var peerAddrs []netip.Addr
-		for _, ip := range peerConf.IPs {
-			addr, err := netip.ParseAddr(ip)
-			if err != nil {
-				return nil, fmt.Errorf("could not parse peer address %q: %v", ip, err)
-			}
-			peerAddrs = append(peerAddrs, addr)
-		}
+	if err := t.Up(); err != nil {
+		_ = net.Close()
+		return nil, fmt.Errorf("failed to bring transport up: %w", err)
+	}
 
-		if err := pd.AddPeer(peerConf.Name, peerPublicKey, peerAddrs); err != nil {
-			return nil, fmt.Errorf("failed to add peer %s to directory: %w", peerConf.Name, err)
+	for _, peerConf := range conf.Peers {
+		if err := net.AddPeer(peerConf); err != nil {
+			_ = net.Close()
+			return nil, fmt.Errorf("failed to add peer %s: %w", peerConf.Name, err)
 		}
+	}
 
-		peer, err := t.NewPeer(peerPublicKey)
+	if conf.NameServer != nil {
+		net.peersMu.Lock()
+		err := net.startNameServerLocked(*conf.NameServer)
+		net.peersMu.Unlock()
 		if err != nil {
-			return nil, fmt.Errorf("failed to create peer: %w", err)
-		}
-
-		// Regularly send keepalives to the peer to keep NAT mappings valid.
-		// This could be configurable but I think it's a good default to avoid footguns.
-		peer.SetKeepAliveInterval(25 * time.Second)
-
-		if peerConf.Endpoint != "" {
-			peerEndpointHost, peerEndpointPortStr, err := stdnet.SplitHostPort(peerConf.Endpoint)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse peer endpoint: %w", err)
-			}
-
-			peerEndpointAddrs, err := stdnet.LookupHost(peerEndpointHost)
-			if err != nil {
-				return nil, fmt.Errorf("failed to resolve peer address: %w", err)
-			}
-
-			peerEndpointPort, err := strconv.Atoi(peerEndpointPortStr)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse peer port: %w", err)
-			}
-
-			peer.SetEndpoint(&conn.StdNetEndpoint{
-				AddrPort: netip.AddrPortFrom(netip.MustParseAddr(peerEndpointAddrs[0]), uint16(peerEndpointPort)),
-			})
-
-			if err := peer.SendKeepalive(); err != nil {
-				logger.Warn("Failed to send initial keepalive", "peer", peerConf.Name, "error", err)
-			}
+			_ = net.Close()
+			return nil, err
 		}
-
 	}
 
-	if err := t.Up(); err != nil {
-		return nil, fmt.Errorf("failed to bring transport up: %w", err)
+	for _, portForwardConf := range conf.PortForwards {
+		if err := net.AddPortForward(portForwardConf); err != nil {
+			_ = net.Close()
+			return nil, fmt.Errorf("failed to add port forward %s: %w", portForwardConf.Name, err)
+		}
 	}
 
-	return &NoisySocketsNetwork{
-		transport:  t,
-		pd:         pd,
-		stack:      s,
-		localAddrs: localAddrs,
-		dnsServers: dnsServers,
-		hasV4:      hasV4,
-		hasV6:      hasV6,
-	}, nil
+	return net, nil
 }
 
 func (net *NoisySocketsNetwork) Close() error {
+	net.portForwardsMu.Lock()
+	for name, pf := range net.portForwards {
+		_ = pf.fwd.Close()
+		delete(net.portForwards, name)
+	}
+	net.portForwardsMu.Unlock()
+
+	net.peersMu.Lock()
+	net.stopNameServerLocked()
+	for pk, r := range net.endpointResolvers {
+		r.Stop()
+		delete(net.endpointResolvers, pk)
+	}
+	net.peersMu.Unlock()
+
 	net.stack.Close()
 	return net.transport.Close()
 }
@@ -313,57 +295,22 @@ func (net *NoisySocketsNetwork) HasIPv6() bool {
 }
 
 func (net *NoisySocketsNetwork) LookupHost(host string) ([]string, error) {
-	var addrs []netip.Addr
-
-	// Host is an IP address.
-	if addr, err := netip.ParseAddr(host); err == nil {
-		addrs = append(addrs, addr)
-
-		goto LOOKUP_HOST_DONE
-	}
-
-	// Host is the name of a peer.
-	if peerAddrs, ok := net.pd.LookupPeerAddressesByName(host); ok {
-		for _, peerAddr := range peerAddrs {
-			if net.hasV4 && peerAddr.Is4() {
-				addrs = append(addrs, peerAddr)
-			} else if net.hasV6 && peerAddr.Is6() {
-				addrs = append(addrs, peerAddr)
-			}
-		}
-
-		goto LOOKUP_HOST_DONE
-	}
-
-	// Host is a DNS name.
-	if len(net.dnsServers) > 0 {
-		var err error
-		addrs, err = dns.LookupHost(net, net.dnsServers, host)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-LOOKUP_HOST_DONE:
-	if len(addrs) == 0 {
-		return nil, &stdnet.DNSError{Err: "no such host", Name: host}
-	}
-
-	addrselect.SortByRFC6724(net, addrs)
-
-	addrsStrings := make([]string, 0, len(addrs))
-	for _, addr := range addrs {
-		addrsStrings = append(addrsStrings, addr.String())
-	}
-
-	return addrsStrings, nil
+	return net.Resolver().LookupHost(context.Background(), host)
 }
 
 func (net *NoisySocketsNetwork) Dial(network, address string) (stdnet.Conn, error) {
 	return net.DialContext(context.Background(), network, address)
 }
 
+// DialContext connects to the address on the named network. In addition to
+// the "tcp" and "udp" networks, ICMP echo sockets can be created with the
+// "ping" and "ip4:icmp" / "ip6:ipv6-icmp" networks, in which case the address
+// is a host without a port.
 func (net *NoisySocketsNetwork) DialContext(ctx context.Context, network, address string) (stdnet.Conn, error) {
+	if isICMPNetwork(network) {
+		return net.dialICMP(ctx, network, address)
+	}
+
 	acceptV4, acceptV6 := true, true
 	matches := protoSplitter.FindStringSubmatch(network)
 	if matches == nil {
@@ -437,12 +384,15 @@ func (net *NoisySocketsNetwork) DialContext(ctx context.Context, network, addres
 			c, err = gonet.DialContextTCP(dialCtx, net.stack, fa, pn)
 		case "udp":
 			c, err = gonet.DialUDP(net.stack, nil, &fa, pn)
+			if err == nil {
+				return &udpConn{Conn: Conn{Conn: c, pd: net.pd}}, nil
+			}
 		}
 		if err == nil {
-			return c, nil
+			return &Conn{Conn: c, pd: net.pd}, nil
 		}
 		if firstErr == nil {
-			firstErr = err
+			firstErr = translateNetstackError(err)
 		}
 	}
 	if firstErr == nil {
@@ -452,6 +402,8 @@ func (net *NoisySocketsNetwork) DialContext(ctx context.Context, network, addres
 	return nil, firstErr
 }
 
+// Listen announces on the local network address. An empty or unspecified
+// host listens on all local addresses of the network.
 func (net *NoisySocketsNetwork) Listen(network, address string) (stdnet.Listener, error) {
 	acceptV4, acceptV6 := true, true
 	matches := protoSplitter.FindStringSubmatch(network)
@@ -476,37 +428,12 @@ func (net *NoisySocketsNetwork) Listen(network, address string) (stdnet.Listener
 		return nil, &stdnet.OpError{Op: "listen", Err: ErrNumericPort}
 	}
 
-	var addr netip.AddrPort
-	if host != "" && !(host == "0.0.0.0" || host == "[::]") {
-		ip, err := netip.ParseAddr(host)
-		if err != nil {
-			return nil, &stdnet.OpError{Op: "listen", Err: err}
-		}
-
-		if ip.Is4() && !acceptV4 {
-			return nil, &stdnet.OpError{Op: "listen", Err: stdnet.UnknownNetworkError("tcp4")}
-		}
-
-		if ip.Is6() && !acceptV6 {
-			return nil, &stdnet.OpError{Op: "listen", Err: stdnet.UnknownNetworkError("tcp6")}
-		}
-
-		addr = netip.AddrPortFrom(ip, uint16(port))
-	} else {
-		for _, localAddr := range net.localAddrs {
-			if localAddr.Is6() && acceptV6 {
-				addr = netip.AddrPortFrom(localAddr, uint16(port))
-				break
-			}
-			if localAddr.Is4() && acceptV4 {
-				addr = netip.AddrPortFrom(localAddr, uint16(port))
-				break
-			}
-		}
+	fa, pn, v6Only, err := net.listenAddr(network, host, uint16(port), acceptV4, acceptV6)
+	if err != nil {
+		return nil, err
 	}
 
-	fa, pn := convertToFullAddr(addr)
-	lis, err := gonet.ListenTCP(net.stack, fa, pn)
+	lis, err := listenTCP(net.stack, fa, pn, v6Only)
 	if err != nil {
 		return nil, err
 	}
@@ -514,7 +441,13 @@ func (net *NoisySocketsNetwork) Listen(network, address string) (stdnet.Listener
 	return &listener{Listener: lis, pd: net.pd}, nil
 }
 
+// ListenPacket announces on the local network address. ICMP echo sockets are
+// supported in addition to "udp", see DialContext.
 func (net *NoisySocketsNetwork) ListenPacket(network, address string) (stdnet.PacketConn, error) {
+	if isICMPNetwork(network) {
+		return net.listenICMP(network, address)
+	}
+
 	acceptV4, acceptV6 := true, true
 	matches := protoSplitter.FindStringSubmatch(network)
 	if matches == nil {
@@ -538,37 +471,12 @@ func (net *NoisySocketsNetwork) ListenPacket(network, address string) (stdnet.Pa
 		return nil, &stdnet.OpError{Op: "listen", Err: ErrNumericPort}
 	}
 
-	var addr netip.AddrPort
-	if host != "" && !(host == "0.0.0.0" || host == "[::]") {
-		ip, err := netip.ParseAddr(host)
-		if err != nil {
-			return nil, &stdnet.OpError{Op: "listen", Err: err}
-		}
-
-		if ip.Is4() && !acceptV4 {
-			return nil, &stdnet.OpError{Op: "listen", Err: stdnet.UnknownNetworkError("udp4")}
-		}
-
-		if ip.Is6() && !acceptV6 {
-			return nil, &stdnet.OpError{Op: "listen", Err: stdnet.UnknownNetworkError("udp6")}
-		}
-
-		addr = netip.AddrPortFrom(ip, uint16(port))
-	} else {
-		for _, localAddr := range net.localAddrs {
-			if localAddr.Is6() && acceptV6 {
-				addr = netip.AddrPortFrom(localAddr, uint16(port))
-				break
-			}
-			if localAddr.Is4() && acceptV4 {
-				addr = netip.AddrPortFrom(localAddr, uint16(port))
-				break
-			}
-		}
+	fa, pn, v6Only, err := net.listenAddr(network, host, uint16(port), acceptV4, acceptV6)
+	if err != nil {
+		return nil, err
 	}
 
-	fa, pn := convertToFullAddr(addr)
-	pc, err := gonet.DialUDP(net.stack, &fa, nil, pn)
+	pc, err := listenUDP(net.stack, fa, pn, v6Only)
 	if err != nil {
 		return nil, err
 	}
@@ -608,6 +516,635 @@ func (net *NoisySocketsNetwork) SetPeerEndpoint(pk types.NoisePublicKey, endpoin
 	return nil
 }
 
+// AddPeer adds a new peer to the network. Peers can be added at any time,
+// including while traffic is flowing to other peers.
+func (net *NoisySocketsNetwork) AddPeer(peerConf v1alpha1.PeerConfig) error {
+	return net.AddPeerContext(context.Background(), peerConf)
+}
+
+// AddPeerContext adds a new peer to the network, see AddPeer. The provided
+// context bounds how long resolving the endpoint of the peer can take.
+func (net *NoisySocketsNetwork) AddPeerContext(ctx context.Context, peerConf v1alpha1.PeerConfig) error {
+	parsed, err := net.preparePeer(ctx, peerConf)
+	if err != nil {
+		return err
+	}
+
+	net.peersMu.Lock()
+	defer net.peersMu.Unlock()
+
+	return net.addPeerLocked(peerConf, parsed)
+}
+
+func (net *NoisySocketsNetwork) addPeerLocked(peerConf v1alpha1.PeerConfig, parsed *parsedPeerConfig) error {
+	peerPublicKey := parsed.publicKey
+
+	if net.transport.LookupPeer(peerPublicKey) != nil {
+		return ErrPeerExists
+	}
+
+	endpoint, err := net.peerEndpointLocked(peerConf, parsed)
+	if err != nil {
+		return err
+	}
+
+	if err := net.pd.AddPeer(peerConf.Name, peerPublicKey, parsed.prefixes); err != nil {
+		return fmt.Errorf("failed to add peer to directory: %w", err)
+	}
+
+	if err := net.startPeerLocked(peerConf, parsed, endpoint); err != nil {
+		net.pd.RemovePeer(peerPublicKey)
+		return err
+	}
+
+	return nil
+}
+
+// startPeerLocked creates and starts the transport peer for a peer that has
+// been added to the directory.
+func (net *NoisySocketsNetwork) startPeerLocked(peerConf v1alpha1.PeerConfig, parsed *parsedPeerConfig, endpoint *peerEndpoint) error {
+	peerPublicKey := parsed.publicKey
+
+	peer, err := net.transport.NewPeer(peerPublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to create peer: %w", err)
+	}
+
+	net.publishEvent(Event{
+		Type:      EventPeerAdded,
+		PublicKey: peerPublicKey,
+		Time:      time.Now(),
+	})
+
+	peer.SetPresharedKey(parsed.presharedKey)
+
+	peer.SetKeepAliveInterval(parsed.keepAliveInterval)
+	peer.SetAdaptiveKeepAlive(parsed.adaptiveKeepAlive)
+
+	if endpoint != nil {
+		peer.SetEndpointCandidates(endpoint.candidates())
+	}
+
+	net.updateRoutes()
+
+	peer.Start()
+
+	if endpoint != nil {
+		if endpoint.isHostname() {
+			net.endpointResolvers[peerPublicKey] = startEndpointResolver(net.logger, peer, endpoint)
+		}
+
+		if err := peer.SendKeepalive(); err != nil {
+			net.logger.Warn("Failed to send initial keepalive", "peer", peerConf.Name, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// UpdatePeer updates the configuration of an existing peer. Any established
+// session with the peer is preserved. If the endpoint is not specified, the
+// current (possibly learned) endpoint of the peer is retained.
+func (net *NoisySocketsNetwork) UpdatePeer(peerConf v1alpha1.PeerConfig) error {
+	return net.UpdatePeerContext(context.Background(), peerConf)
+}
+
+// UpdatePeerContext updates the configuration of an existing peer, see
+// UpdatePeer. The provided context bounds how long resolving a changed
+// endpoint can take.
+func (net *NoisySocketsNetwork) UpdatePeerContext(ctx context.Context, peerConf v1alpha1.PeerConfig) error {
+	parsed, err := net.preparePeer(ctx, peerConf)
+	if err != nil {
+		return err
+	}
+
+	net.peersMu.Lock()
+	defer net.peersMu.Unlock()
+
+	return net.updatePeerLocked(peerConf, parsed)
+}
+
+func (net *NoisySocketsNetwork) updatePeerLocked(peerConf v1alpha1.PeerConfig, parsed *parsedPeerConfig) error {
+	peerPublicKey := parsed.publicKey
+
+	peer := net.transport.LookupPeer(peerPublicKey)
+	if peer == nil {
+		return ErrUnknownPeer
+	}
+
+	endpoint, err := net.peerEndpointLocked(peerConf, parsed)
+	if err != nil {
+		return err
+	}
+
+	if err := net.pd.UpdatePeer(peerConf.Name, peerPublicKey, parsed.prefixes); err != nil {
+		return fmt.Errorf("failed to update peer in directory: %w", err)
+	}
+
+	net.reconfigurePeerLocked(peer, peerConf, parsed, endpoint)
+
+	return nil
+}
+
+// reconfigurePeerLocked applies the configuration of a peer, whose entry in
+// the directory has already been updated, to its transport peer.
+func (net *NoisySocketsNetwork) reconfigurePeerLocked(peer *transport.Peer, peerConf v1alpha1.PeerConfig, parsed *parsedPeerConfig, endpoint *peerEndpoint) {
+	peerPublicKey := parsed.publicKey
+
+	peer.SetPresharedKey(parsed.presharedKey)
+	peer.SetKeepAliveInterval(parsed.keepAliveInterval)
+	peer.SetAdaptiveKeepAlive(parsed.adaptiveKeepAlive)
+
+	net.updateRoutes()
+
+	if r, ok := net.endpointResolvers[peerPublicKey]; ok && r.endpoint != endpoint {
+		r.Stop()
+		delete(net.endpointResolvers, peerPublicKey)
+	}
+
+	if endpoint != nil {
+		if _, ok := net.endpointResolvers[peerPublicKey]; !ok {
+			currentEndpoint := peer.GetEndpoint()
+
+			candidates := endpoint.candidates()
+			peer.SetEndpointCandidates(candidates)
+
+			// An explicitly configured endpoint takes precedence over a roamed one.
+			if !slices.ContainsFunc(endpoint.addrs, func(addr netip.AddrPort) bool {
+				return currentEndpoint != nil && currentEndpoint.DstToString() == addr.String()
+			}) {
+				peer.SetEndpoint(candidates[0])
+
+				if err := peer.SendKeepalive(); err != nil {
+					net.logger.Warn("Failed to send keepalive", "peer", peerConf.Name, "error", err)
+				}
+			}
+
+			if endpoint.isHostname() {
+				net.endpointResolvers[peerPublicKey] = startEndpointResolver(net.logger, peer, endpoint)
+			}
+		}
+	} else {
+		peer.SetEndpointCandidates(nil)
+	}
+}
+
+// RemovePeer removes a peer from the network. Any connections to the peer
+// will be left dangling, and will eventually time out.
+func (net *NoisySocketsNetwork) RemovePeer(pk types.NoisePublicKey) error {
+	net.peersMu.Lock()
+	defer net.peersMu.Unlock()
+
+	return net.removePeerLocked(pk)
+}
+
+func (net *NoisySocketsNetwork) removePeerLocked(pk types.NoisePublicKey) error {
+	if net.transport.LookupPeer(pk) == nil {
+		return ErrUnknownPeer
+	}
+
+	// Stop routing packets to the peer before tearing it down.
+	net.pd.RemovePeer(pk)
+	net.updateRoutes()
+
+	if r, ok := net.endpointResolvers[pk]; ok {
+		r.Stop()
+		delete(net.endpointResolvers, pk)
+	}
+
+	net.transport.RemovePeer(pk)
+	net.acl.ForgetPeer(pk)
+
+	net.publishEvent(Event{
+		Type:      EventPeerRemoved,
+		PublicKey: pk,
+		Time:      time.Now(),
+	})
+
+	return nil
+}
+
+// Reconfigure applies the given configuration to the running network without
+// disrupting existing connections. Only the differences from the running
+// configuration are applied: peers and port forwards are added, updated or
+// removed, and the DNS servers, name server, listen port and private key are
+// changed if required. Changing the local IP addresses or MTU is not supported and
+// returns ErrUnsupportedChange.
+// The configuration is checked for conflicts before any changes are made, so
+// names and addresses can be swapped between peers. If an error is returned
+// while applying it (eg. a port forward fails to start), the configuration may
+// have been partially applied.
+func (net *NoisySocketsNetwork) Reconfigure(conf *v1alpha1.Config) error {
+	return net.ReconfigureContext(context.Background(), conf)
+}
+
+// ReconfigureContext applies the given configuration to the running network,
+// see Reconfigure. The provided context bounds how long resolving the
+// endpoints of new or changed peers can take.
+func (net *NoisySocketsNetwork) ReconfigureContext(ctx context.Context, conf *v1alpha1.Config) error {
+	if err := config.Validate(conf); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	var privateKey types.NoisePrivateKey
+	if err := privateKey.FromString(conf.PrivateKey); err != nil {
+		return fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	var localAddrs []netip.Addr
+	for _, ip := range conf.IPs {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return fmt.Errorf("could not parse address: %w", err)
+		}
+		localAddrs = append(localAddrs, addr)
+	}
+
+	if !slices.Equal(localAddrs, net.localAddrs) {
+		return fmt.Errorf("failed to change addresses: %w", ErrUnsupportedChange)
+	}
+
+	mtu := conf.MTU
+	if mtu == 0 {
+		mtu = transport.DefaultMTU
+	}
+
+	if mtu != net.transport.MTU() {
+		return fmt.Errorf("failed to change mtu: %w", ErrUnsupportedChange)
+	}
+
+	dnsServers, err := parseDNSServers(conf.DNSServers)
+	if err != nil {
+		return err
+	}
+
+	aclPolicy, err := parseACL(conf.ACL)
+	if err != nil {
+		return err
+	}
+
+	// Check for conflicts against the desired state of the directory, before
+	// anything is changed. Names and addresses can then be moved between
+	// peers, as all of them are updated at once.
+	localPrefixes := make([]netip.Prefix, 0, len(localAddrs))
+	for _, addr := range localAddrs {
+		localPrefixes = append(localPrefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	pd := newPeerDirectory()
+	if err := pd.AddPeer(conf.Name, privateKey.PublicKey(), localPrefixes); err != nil {
+		return fmt.Errorf("could not add local peer to directory: %w", err)
+	}
+
+	parsedPeers := make([]*parsedPeerConfig, 0, len(conf.Peers))
+	for _, peerConf := range conf.Peers {
+		parsed, err := net.preparePeer(ctx, peerConf)
+		if err != nil {
+			return fmt.Errorf("failed to prepare peer %s: %w", peerConf.Name, err)
+		}
+
+		if err := pd.AddPeer(peerConf.Name, parsed.publicKey, parsed.prefixes); err != nil {
+			return fmt.Errorf("failed to add peer %s to directory: %w", peerConf.Name, err)
+		}
+
+		parsedPeers = append(parsedPeers, parsed)
+	}
+
+	if err := net.reconfigurePeers(conf, privateKey, pd, parsedPeers, dnsServers, aclPolicy); err != nil {
+		return err
+	}
+
+	return net.reconcilePortForwards(conf.PortForwards)
+}
+
+// reconfigurePeers applies the peers, name server, DNS and ACL configuration
+// under the peers lock, pd is the desired (and already checked) directory.
+func (net *NoisySocketsNetwork) reconfigurePeers(conf *v1alpha1.Config, privateKey types.NoisePrivateKey,
+	pd *peerDirectory, parsedPeers []*parsedPeerConfig, dnsServers []serveraddr.Addr, aclPolicy *aclPolicy) error {
+	net.peersMu.Lock()
+	defer net.peersMu.Unlock()
+
+	endpoints := make([]*peerEndpoint, 0, len(conf.Peers))
+	for i, peerConf := range conf.Peers {
+		endpoint, err := net.peerEndpointLocked(peerConf, parsedPeers[i])
+		if err != nil {
+			return fmt.Errorf("failed to prepare peer %s: %w", peerConf.Name, err)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	if conf.ListenPort != net.listenPort {
+		if err := net.transport.UpdatePort(conf.ListenPort); err != nil {
+			return fmt.Errorf("failed to update port: %w", err)
+		}
+
+		net.listenPort = conf.ListenPort
+	}
+
+	net.resolver.SetConfig(dns.ResolverConfig{
+		Servers: dnsServers,
+		Timeout: conf.DNSTimeout,
+	})
+
+	net.acl.SetPolicy(aclPolicy)
+
+	wantPeers := make(map[types.NoisePublicKey]bool)
+	for _, parsed := range parsedPeers {
+		wantPeers[parsed.publicKey] = true
+	}
+
+	for _, pk := range net.transport.Peers() {
+		if !wantPeers[pk] {
+			if err := net.removePeerLocked(pk); err != nil {
+				return fmt.Errorf("failed to remove peer %s: %w", pk, err)
+			}
+		}
+	}
+
+	net.pd.Replace(pd)
+	net.updateRoutes()
+
+	net.name = conf.Name
+	if !privateKey.Equals(net.privateKey) {
+		// Re-keying resets all sessions, the peers will handshake again with
+		// the new key.
+		net.transport.SetPrivateKey(privateKey)
+		net.privateKey = privateKey
+	}
+
+	// Update existing peers before adding new ones.
+	var newPeers []int
+	for i, peerConf := range conf.Peers {
+		peer := net.transport.LookupPeer(parsedPeers[i].publicKey)
+		if peer == nil {
+			newPeers = append(newPeers, i)
+			continue
+		}
+
+		net.reconfigurePeerLocked(peer, peerConf, parsedPeers[i], endpoints[i])
+	}
+
+	for _, i := range newPeers {
+		if err := net.startPeerLocked(conf.Peers[i], parsedPeers[i], endpoints[i]); err != nil {
+			return fmt.Errorf("failed to add peer %s: %w", conf.Peers[i].Name, err)
+		}
+	}
+
+	return net.reconcileNameServerLocked(conf.NameServer)
+}
+
+// FlushDNSCache removes all cached DNS responses, so that subsequent lookups
+// query the DNS servers again.
+func (net *NoisySocketsNetwork) FlushDNSCache() {
+	net.resolver.FlushCache()
+}
+
+func (net *NoisySocketsNetwork) getDNSServers() []serveraddr.Addr {
+	return net.resolver.Config().Servers
+}
+
+// updateRoutes rebuilds the netstack routing table from the allowed IPs of
+// all peers, it must be called whenever the allowed IPs change.
+func (net *NoisySocketsNetwork) updateRoutes() {
+	var routes []tcpip.Route
+	// The netstack uses the first matching route, and prefixes are ordered
+	// from most to least specific, so this gives us longest prefix match.
+	for _, prefix := range net.pd.Prefixes() {
+		routes = append(routes, tcpip.Route{
+			Destination: tcpip.AddressWithPrefix{
+				Address:   tcpip.AddrFromSlice(prefix.Addr().AsSlice()),
+				PrefixLen: prefix.Bits(),
+			}.Subnet(),
+			NIC: 1,
+		})
+	}
+
+	net.stack.SetRouteTable(routes)
+}
+
+// parsedPeerConfig is a peer configuration that has been parsed into its native types.
+type parsedPeerConfig struct {
+	publicKey    types.NoisePublicKey
+	presharedKey types.NoisePresharedKey
+	// prefixes are the allowed IPs of the peer.
+	prefixes          []netip.Prefix
+	keepAliveInterval time.Duration
+	adaptiveKeepAlive bool
+	// endpoint is the resolved endpoint of the peer, it is nil if the peer has
+	// no endpoint or if its endpoint is unchanged (see preparePeer).
+	endpoint *peerEndpoint
+}
+
+func parsePeerConfig(peerConf v1alpha1.PeerConfig) (*parsedPeerConfig, error) {
+	var parsed parsedPeerConfig
+	if err := parsed.publicKey.FromString(peerConf.PublicKey); err != nil {
+		return nil, fmt.Errorf("failed to parse peer public key: %w", err)
+	}
+
+	if peerConf.PresharedKey != "" {
+		if err := parsed.presharedKey.FromString(peerConf.PresharedKey); err != nil {
+			return nil, fmt.Errorf("failed to parse peer preshared key: %w", err)
+		}
+	}
+
+	for _, ip := range peerConf.IPs {
+		prefix, err := config.ParsePrefix(ip)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse peer address %q: %v", ip, err)
+		}
+		parsed.prefixes = append(parsed.prefixes, prefix)
+	}
+
+	// Regularly send keepalives to the peer to keep NAT mappings valid.
+	// This is enabled by default to avoid footguns.
+	parsed.keepAliveInterval = v1alpha1.DefaultPersistentKeepalive
+	if peerConf.PersistentKeepalive != nil {
+		parsed.keepAliveInterval = *peerConf.PersistentKeepalive
+	}
+	parsed.adaptiveKeepAlive = peerConf.AdaptiveKeepalive
+
+	if peerConf.DefaultGateway {
+		parsed.prefixes = append(parsed.prefixes,
+			netip.PrefixFrom(netip.IPv4Unspecified(), 0),
+			netip.PrefixFrom(netip.IPv6Unspecified(), 0))
+	}
+
+	return &parsed, nil
+}
+
+// preparePeer validates and parses the configuration of a peer, and resolves
+// its endpoint. It must be called without holding peersMu, as resolving the
+// endpoint can block for some time. An endpoint that is unchanged from the running
+// configuration is not resolved again.
+func (net *NoisySocketsNetwork) preparePeer(ctx context.Context, peerConf v1alpha1.PeerConfig) (*parsedPeerConfig, error) {
+	if err := config.ValidatePeer(peerConf); err != nil {
+		return nil, fmt.Errorf("invalid peer config: %w", err)
+	}
+
+	parsed, err := parsePeerConfig(peerConf)
+	if err != nil {
+		return nil, err
+	}
+
+	if peerConf.Endpoint == "" {
+		return parsed, nil
+	}
+
+	net.peersMu.Lock()
+	r, ok := net.endpointResolvers[parsed.publicKey]
+	net.peersMu.Unlock()
+
+	if ok && r.endpoint.endpoint == peerConf.Endpoint {
+		return parsed, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, endpointLookupTimeout)
+	defer cancel()
+
+	parsed.endpoint, err = resolvePeerEndpoint(ctx, peerConf.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
+
+// peerEndpointLocked returns the endpoint of a prepared peer, reusing the
+// endpoint of the running resolver if it is unchanged.
+func (net *NoisySocketsNetwork) peerEndpointLocked(peerConf v1alpha1.PeerConfig, parsed *parsedPeerConfig) (*peerEndpoint, error) {
+	if r, ok := net.endpointResolvers[parsed.publicKey]; ok && r.endpoint.endpoint == peerConf.Endpoint {
+		return r.endpoint, nil
+	}
+
+	// The peer was changed concurrently, after its endpoint was prepared.
+	if parsed.endpoint == nil && peerConf.Endpoint != "" {
+		return nil, fmt.Errorf("peer endpoint %q was not resolved", peerConf.Endpoint)
+	}
+
+	return parsed.endpoint, nil
+}
+
+func parseDNSServers(addrs []string) ([]serveraddr.Addr, error) {
+	var dnsServers []serveraddr.Addr
+	for _, addr := range addrs {
+		dnsServer, err := serveraddr.Parse(addr)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse DNS server address: %w", err)
+		}
+
+		dnsServers = append(dnsServers, dnsServer)
+	}
+
+	return dnsServers, nil
+}
+
+// listenAddr returns the local address to bind a listening socket to. As with
+// the standard library, an empty or unspecified host binds to all local
+// addresses, and if both address families are accepted a single dual-stack
+// IPv6 socket is used.
+func (net *NoisySocketsNetwork) listenAddr(network, host string, port uint16, acceptV4, acceptV6 bool) (tcpip.FullAddress, tcpip.NetworkProtocolNumber, bool, error) {
+	if host != "" && !(host == "0.0.0.0" || host == "::") {
+		ip, err := netip.ParseAddr(host)
+		if err != nil {
+			return tcpip.FullAddress{}, 0, false, &stdnet.OpError{Op: "listen", Net: network, Err: err}
+		}
+
+		if (ip.Is4() && !acceptV4) || (ip.Is6() && !acceptV6) {
+			return tcpip.FullAddress{}, 0, false, &stdnet.OpError{Op: "listen", Net: network, Err: ErrNoSuitableAddress}
+		}
+
+		fa, pn := convertToFullAddr(netip.AddrPortFrom(ip, port))
+		return fa, pn, false, nil
+	}
+
+	acceptV4 = acceptV4 && net.hasV4
+	acceptV6 = acceptV6 && net.hasV6
+
+	fa := tcpip.FullAddress{NIC: 1, Port: port}
+	switch {
+	case acceptV6:
+		// Only accept IPv4 connections (as v4-mapped addresses) if requested.
+		return fa, ipv6.ProtocolNumber, !acceptV4, nil
+	case acceptV4:
+		return fa, ipv4.ProtocolNumber, false, nil
+	default:
+		return tcpip.FullAddress{}, 0, false, &stdnet.OpError{Op: "listen", Net: network, Err: ErrNoSuitableAddress}
+	}
+}
+
+func listenTCP(s *stack.Stack, addr tcpip.FullAddress, pn tcpip.NetworkProtocolNumber, v6Only bool) (*gonet.TCPListener, error) {
+	var wq waiter.Queue
+	ep, tcpipErr := s.NewEndpoint(tcp.ProtocolNumber, pn, &wq)
+	if tcpipErr != nil {
+		return nil, errors.New(tcpipErr.String())
+	}
+
+	if pn == ipv6.ProtocolNumber {
+		ep.SocketOptions().SetV6Only(v6Only)
+	}
+
+	if tcpipErr := ep.Bind(addr); tcpipErr != nil {
+		ep.Close()
+		return nil, &stdnet.OpError{Op: "bind", Net: "tcp", Addr: fullToTCPAddr(addr), Err: errors.New(tcpipErr.String())}
+	}
+
+	if tcpipErr := ep.Listen(listenBacklog); tcpipErr != nil {
+		ep.Close()
+		return nil, &stdnet.OpError{Op: "listen", Net: "tcp", Addr: fullToTCPAddr(addr), Err: errors.New(tcpipErr.String())}
+	}
+
+	return gonet.NewTCPListener(s, &wq, ep), nil
+}
+
+func listenUDP(s *stack.Stack, addr tcpip.FullAddress, pn tcpip.NetworkProtocolNumber, v6Only bool) (*gonet.UDPConn, error) {
+	var wq waiter.Queue
+	ep, tcpipErr := s.NewEndpoint(udp.ProtocolNumber, pn, &wq)
+	if tcpipErr != nil {
+		return nil, errors.New(tcpipErr.String())
+	}
+
+	if pn == ipv6.ProtocolNumber {
+		ep.SocketOptions().SetV6Only(v6Only)
+	}
+
+	if tcpipErr := ep.Bind(addr); tcpipErr != nil {
+		ep.Close()
+		return nil, &stdnet.OpError{Op: "bind", Net: "udp", Addr: fullToUDPAddr(addr), Err: errors.New(tcpipErr.String())}
+	}
+
+	return gonet.NewUDPConn(&wq, ep), nil
+}
+
+func fullToTCPAddr(addr tcpip.FullAddress) *stdnet.TCPAddr {
+	return &stdnet.TCPAddr{IP: stdnet.IP(addr.Addr.AsSlice()), Port: int(addr.Port)}
+}
+
+func fullToUDPAddr(addr tcpip.FullAddress) *stdnet.UDPAddr {
+	return &stdnet.UDPAddr{IP: stdnet.IP(addr.Addr.AsSlice()), Port: int(addr.Port)}
+}
+
+// netstackErrnos maps the messages of netstack errors to the equivalent
+// system errors. The netstack adapters only preserve the message of an error.
+var netstackErrnos = map[string]syscall.Errno{
+	(&tcpip.ErrConnectionRefused{}).String():  syscall.ECONNREFUSED,
+	(&tcpip.ErrHostUnreachable{}).String():    syscall.EHOSTUNREACH,
+	(&tcpip.ErrNetworkUnreachable{}).String(): syscall.ENETUNREACH,
+}
+
+// translateNetstackError replaces the netstack error wrapped by an OpError
+// with the equivalent system error, so that callers can use errors.Is() as
+// they would with the standard library (eg. with syscall.ECONNREFUSED).
+func translateNetstackError(err error) error {
+	var opErr *stdnet.OpError
+	if errors.As(err, &opErr) && opErr.Err != nil {
+		if errno, ok := netstackErrnos[opErr.Err.Error()]; ok {
+			opErr.Err = os.NewSyscallError(opErr.Op, errno)
+		}
+	}
+
+	return err
+}
+
 func convertToFullAddr(endpoint netip.AddrPort) (tcpip.FullAddress, tcpip.NetworkProtocolNumber) {
 	var protoNumber tcpip.NetworkProtocolNumber
 	if endpoint.Addr().Is4() {
@@ -15,14 +15,18 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	stdnet "net"
 	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/docker/docker/api/types/container"
+	"github.com/miekg/dns"
 	"github.com/neilotoole/slogt"
 	"github.com/noisysockets/noisysockets"
 	"github.com/noisysockets/noisysockets/config"
@@ -33,6 +37,8 @@ import (
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/network"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
 )
 
 func TestNetwork(t *testing.T) {
@@ -44,6 +50,9 @@ func TestNetwork(t *testing.T) {
 	clientPrivateKey, err := types.NewPrivateKey()
 	require.NoError(t, err)
 
+	presharedKey, err := types.NewPresharedKey()
+	require.NoError(t, err)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	t.Cleanup(func() {
 		cancel()
@@ -60,8 +69,9 @@ func TestNetwork(t *testing.T) {
 			IPs:        []string{"10.7.0.1"},
 			Peers: []v1alpha1.PeerConfig{
 				{
-					PublicKey: clientPrivateKey.PublicKey().String(),
-					IPs:       []string{"10.7.0.2"},
+					PublicKey:    clientPrivateKey.PublicKey().String(),
+					PresharedKey: presharedKey.String(),
+					IPs:          []string{"10.7.0.2"},
 				},
 			},
 		}
@@ -77,9 +87,19 @@ func TestNetwork(t *testing.T) {
 		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprint(w, "Hello, world!")
 		})
+		mux.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+			peer, ok := noisysockets.PeerFromContext(r.Context())
+			if !ok {
+				http.Error(w, "unknown peer", http.StatusForbidden)
+				return
+			}
+
+			fmt.Fprint(w, peer.PublicKey.String())
+		})
 
 		srv := &http.Server{
-			Handler: &mux,
+			Handler:     &mux,
+			ConnContext: noisysockets.ConnContext,
 		}
 		defer srv.Close()
 
@@ -139,10 +159,11 @@ func TestNetwork(t *testing.T) {
 		IPs:        []string{"10.7.0.2"},
 		Peers: []v1alpha1.PeerConfig{
 			{
-				Name:      "server",
-				PublicKey: serverPrivateKey.PublicKey().String(),
-				Endpoint:  "localhost:12345",
-				IPs:       []string{"10.7.0.1"},
+				Name:         "server",
+				PublicKey:    serverPrivateKey.PublicKey().String(),
+				PresharedKey: presharedKey.String(),
+				Endpoint:     "localhost:12345",
+				IPs:          []string{"10.7.0.1"},
 			},
 		},
 	}
@@ -178,6 +199,48 @@ func TestNetwork(t *testing.T) {
 		assert.Equal(t, "Hello, world!", string(body))
 	})
 
+	t.Run("Identity", func(t *testing.T) {
+		var serverPeer *noisysockets.PeerIdentity
+
+		client := &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (stdnet.Conn, error) {
+					conn, err := net.DialContext(ctx, network, addr)
+					if err != nil {
+						return nil, err
+					}
+
+					// The client can also verify the identity of the server.
+					peer, ok := noisysockets.PeerFromConn(conn)
+					if !ok {
+						_ = conn.Close()
+						return nil, fmt.Errorf("unknown peer %s", conn.RemoteAddr())
+					}
+					serverPeer = peer
+
+					return conn, nil
+				},
+			},
+		}
+
+		resp, err := client.Get("http://server/whoami")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, resp.Body.Close())
+		})
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		require.Equal(t, clientPrivateKey.PublicKey().String(), string(body))
+
+		require.NotNil(t, serverPeer)
+		require.Equal(t, serverPrivateKey.PublicKey(), serverPeer.PublicKey)
+		require.Equal(t, "server", serverPeer.Name)
+	})
+
 	t.Run("UDP", func(t *testing.T) {
 		conn, err := net.Dial("udp", "server:10000")
 		require.NoError(t, err)
@@ -193,113 +256,1348 @@ func TestNetwork(t *testing.T) {
 
 		assert.Equal(t, "Hello, world!", string(buf[:n]))
 	})
+
+	t.Run("ICMP", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		result, err := net.Ping(ctx, "server")
+		require.NoError(t, err)
+		assert.NotZero(t, result.RTT)
+		assert.Equal(t, netip.MustParseAddr("10.7.0.1"), result.Addr)
+		require.NotNil(t, result.Peer)
+		assert.Equal(t, "server", result.Peer.Name)
+		assert.Equal(t, serverPrivateKey.PublicKey(), result.Peer.PublicKey)
+
+		conn, err := net.Dial("ip4:icmp", "server")
+		require.NoError(t, err)
+		defer conn.Close()
+
+		req, err := (&icmp.Message{
+			Type: ipv4.ICMPTypeEcho,
+			Body: &icmp.Echo{Seq: 7, Data: []byte("Hello, world!")},
+		}).Marshal(nil)
+		require.NoError(t, err)
+
+		_, err = conn.Write(req)
+		require.NoError(t, err)
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+
+		reply, err := icmp.ParseMessage(1, buf[:n])
+		require.NoError(t, err)
+
+		assert.Equal(t, ipv4.ICMPTypeEchoReply, reply.Type)
+		assert.Equal(t, 7, reply.Body.(*icmp.Echo).Seq)
+		assert.Equal(t, "Hello, world!", string(reply.Body.(*icmp.Echo).Data))
+
+		peer, ok := noisysockets.PeerFromAddr(conn.RemoteAddr())
+		require.True(t, ok)
+		assert.Equal(t, "server", peer.Name)
+
+		// Unconnected sockets are bound to all local addresses.
+		pc, err := net.ListenPacket("ping", "")
+		require.NoError(t, err)
+		defer pc.Close()
+
+		_, err = pc.WriteTo(req, &stdnet.IPAddr{IP: stdnet.ParseIP("10.7.0.1")})
+		require.NoError(t, err)
+
+		require.NoError(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+		n, addr, err := pc.ReadFrom(buf)
+		require.NoError(t, err)
+
+		reply, err = icmp.ParseMessage(1, buf[:n])
+		require.NoError(t, err)
+		assert.Equal(t, ipv4.ICMPTypeEchoReply, reply.Type)
+
+		peer, ok = noisysockets.PeerFromAddr(addr)
+		require.True(t, ok)
+		assert.Equal(t, "server", peer.Name)
+
+		_, err = net.Dial("ip6:ipv6-icmp", "server")
+		require.ErrorIs(t, err, noisysockets.ErrNoSuitableAddress)
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		stats, err := net.GetPeerStats(serverPrivateKey.PublicKey())
+		require.NoError(t, err)
+
+		assert.Equal(t, "server", stats.Name)
+		assert.True(t, stats.Endpoint.IsValid())
+		assert.NotZero(t, stats.TxBytes)
+		assert.NotZero(t, stats.RxBytes)
+		assert.False(t, stats.LastHandshake.IsZero())
+		assert.True(t, stats.HasValidKeypair)
+		assert.Equal(t, 25*time.Second, stats.KeepaliveInterval)
+
+		allStats := net.GetAllPeerStats()
+		assert.Len(t, allStats, 1)
+		assert.Contains(t, allStats, serverPrivateKey.PublicKey())
+
+		_, err = net.GetPeerStats(clientPrivateKey.PublicKey())
+		require.ErrorIs(t, err, noisysockets.ErrUnknownPeer)
+	})
 }
 
-func TestWireGuardCompatibility(t *testing.T) {
-	pwd, err := os.Getwd()
+func TestPeerManagement(t *testing.T) {
+	logger := slogt.New(t)
+
+	serverPrivateKey, err := types.NewPrivateKey()
 	require.NoError(t, err)
 
-	ctx := context.Background()
-	testNet, err := network.New(ctx, network.WithCheckDuplicate())
+	clientPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	serverNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "server",
+		ListenPort: 12347,
+		PrivateKey: serverPrivateKey.String(),
+		IPs:        []string{"10.8.0.1"},
+	})
 	require.NoError(t, err)
 	t.Cleanup(func() {
-		require.NoError(t, testNet.Remove(ctx))
+		require.NoError(t, serverNet.Close())
 	})
 
-	nginxReq := testcontainers.ContainerRequest{
-		Image:        "nginx:latest",
-		ExposedPorts: []string{"80/tcp"},
-		Networks:     []string{testNet.Name},
-		NetworkAliases: map[string][]string{
-			testNet.Name: {"web"},
-		},
-		WaitingFor: wait.ForListeningPort("80/tcp"),
+	clientPeerConf := v1alpha1.PeerConfig{
+		Name:      "client",
+		PublicKey: clientPrivateKey.PublicKey().String(),
+		IPs:       []string{"10.8.0.2"},
 	}
 
-	nginxC, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
-		ContainerRequest: nginxReq,
-		Started:          true,
+	// Invalid peer configs are rejected, as they would be by config.Validate.
+	invalidPeerConf := clientPeerConf
+	invalidPeerConf.PresharedKey = "YWJj"
+	var fieldErr *config.FieldError
+	require.ErrorAs(t, serverNet.AddPeer(invalidPeerConf), &fieldErr)
+
+	require.NoError(t, serverNet.AddPeer(clientPeerConf))
+	require.ErrorIs(t, serverNet.AddPeer(clientPeerConf), noisysockets.ErrPeerExists)
+
+	events := make(chan noisysockets.Event, 16)
+	t.Cleanup(serverNet.Subscribe(events))
+
+	lis, err := serverNet.Listen("tcp", ":80")
+	require.NoError(t, err)
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "Hello, world!")
+		}),
+	}
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	go func() {
+		if err := srv.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Failed to serve", "error", err)
+		}
+	}()
+
+	clientNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "client",
+		ListenPort: 12348,
+		// Use a smaller MTU than the server, the MSS should be negotiated down.
+		MTU:        1280,
+		PrivateKey: clientPrivateKey.String(),
+		IPs:        []string{"10.8.0.2"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "server",
+				PublicKey: serverPrivateKey.PublicKey().String(),
+				Endpoint:  "localhost:12347",
+				IPs:       []string{"10.8.0.1"},
+			},
+		},
 	})
 	require.NoError(t, err)
 	t.Cleanup(func() {
-		require.NoError(t, nginxC.Terminate(ctx))
+		require.NoError(t, clientNet.Close())
 	})
 
-	// Spin up a WireGuard gateway.
-	wgReq := testcontainers.ContainerRequest{
-		Image:        "ghcr.io/noisysockets/gateway:v0.1.0",
-		ExposedPorts: []string{"51820/udp"},
-		Files: []testcontainers.ContainerFile{
-			{HostFilePath: filepath.Join(pwd, "testdata/wg0.conf"), ContainerFilePath: "/etc/wireguard/wg0.conf", FileMode: 0o400},
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: clientNet.DialContext,
 		},
-		Networks: []string{testNet.Name},
-		HostConfigModifier: func(hostConfig *container.HostConfig) {
-			hostConfig.CapAdd = []string{"NET_ADMIN"}
+		Timeout: 5 * time.Second,
+	}
 
-			hostConfig.Sysctls = map[string]string{
-				"net.ipv4.ip_forward":              "1",
-				"net.ipv4.conf.all.src_valid_mark": "1",
-			}
+	resp, err := client.Get("http://server")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
 
-			hostConfig.Binds = append(hostConfig.Binds, "/dev/net/tun:/dev/net/tun")
-		},
+	// The server should have learned the endpoint of the client.
+	event := waitForEvent(t, events, noisysockets.EventPeerRoamed)
+	require.Equal(t, clientPrivateKey.PublicKey(), event.PublicKey)
+	require.Equal(t, uint16(12348), event.Endpoint.Port())
+
+	event = waitForEvent(t, events, noisysockets.EventHandshakeCompleted)
+	require.Equal(t, clientPrivateKey.PublicKey(), event.PublicKey)
+	require.False(t, event.Time.IsZero())
+
+	// Rename the peer.
+	clientPeerConf.Name = "client2"
+	require.NoError(t, serverNet.UpdatePeer(clientPeerConf))
+
+	_, err = serverNet.LookupHost("client")
+	require.Error(t, err)
+
+	addrs, err := serverNet.LookupHost("client2")
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.8.0.2"}, addrs)
+
+	// Remove the peer.
+	require.NoError(t, serverNet.RemovePeer(clientPrivateKey.PublicKey()))
+	require.ErrorIs(t, serverNet.RemovePeer(clientPrivateKey.PublicKey()), noisysockets.ErrUnknownPeer)
+	require.Empty(t, serverNet.KnownPeers())
+
+	event = waitForEvent(t, events, noisysockets.EventPeerRemoved)
+	require.Equal(t, clientPrivateKey.PublicKey(), event.PublicKey)
+
+	resp, err = client.Get("http://server")
+	if err == nil {
+		_ = resp.Body.Close()
 	}
+	require.Error(t, err)
+}
 
-	wgC, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
-		ContainerRequest: wgReq,
-		Started:          true,
+func TestListenWildcard(t *testing.T) {
+	logger := slogt.New(t)
+
+	serverPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	clientPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	serverNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "server",
+		ListenPort: 12352,
+		PrivateKey: serverPrivateKey.String(),
+		IPs:        []string{"10.10.0.1", "fd00:10::1"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				PublicKey: clientPrivateKey.PublicKey().String(),
+				IPs:       []string{"10.10.0.2", "fd00:10::2"},
+			},
+		},
 	})
 	require.NoError(t, err)
 	t.Cleanup(func() {
-		require.NoError(t, wgC.Terminate(ctx))
+		require.NoError(t, serverNet.Close())
 	})
 
-	outputDir := t.TempDir()
-	configPath := filepath.Join(outputDir, "noisysockets.yaml")
+	clientNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "client",
+		ListenPort: 12353,
+		PrivateKey: clientPrivateKey.String(),
+		IPs:        []string{"10.10.0.2", "fd00:10::2"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "server",
+				PublicKey: serverPrivateKey.PublicKey().String(),
+				Endpoint:  "localhost:12352",
+				IPs:       []string{"10.10.0.1", "fd00:10::1"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, clientNet.Close())
+	})
 
-	require.NoError(t, generateConfig(ctx, configPath, wgC))
+	t.Run("TCP", func(t *testing.T) {
+		lis, err := serverNet.Listen("tcp", ":8080")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = lis.Close()
+		})
+
+		for _, tc := range []struct{ local, remote string }{
+			{"10.10.0.1:8080", "10.10.0.2"},
+			{"[fd00:10::1]:8080", "fd00:10::2"},
+		} {
+			errCh := make(chan error, 1)
+			go func() {
+				conn, err := clientNet.Dial("tcp", tc.local)
+				if err == nil {
+					_ = conn.Close()
+				}
+				errCh <- err
+			}()
+
+			conn, err := lis.Accept()
+			require.NoError(t, err)
+
+			require.Equal(t, tc.local, conn.LocalAddr().String())
+			require.Equal(t, tc.remote, netip.MustParseAddrPort(conn.RemoteAddr().String()).Addr().String())
+			require.NoError(t, conn.Close())
+
+			require.NoError(t, <-errCh)
+		}
+	})
+
+	t.Run("TCP4", func(t *testing.T) {
+		lis, err := serverNet.Listen("tcp4", "0.0.0.0:8081")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = lis.Close()
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, err = clientNet.DialContext(ctx, "tcp", "[fd00:10::1]:8081")
+		require.Error(t, err)
+	})
 
+	t.Run("UDP", func(t *testing.T) {
+		pc, err := serverNet.ListenPacket("udp", "[::]:10000")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = pc.Close()
+		})
+
+		for _, tc := range []struct{ local, remote string }{
+			{"10.10.0.1:10000", "10.10.0.2"},
+			{"[fd00:10::1]:10000", "fd00:10::2"},
+		} {
+			conn, err := clientNet.Dial("udp", tc.local)
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				_ = conn.Close()
+			})
+
+			_, err = conn.Write([]byte("Hello, world!"))
+			require.NoError(t, err)
+
+			require.NoError(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+			buf := make([]byte, 1024)
+			n, addr, err := pc.ReadFrom(buf)
+			require.NoError(t, err)
+			require.Equal(t, "Hello, world!", string(buf[:n]))
+			require.Equal(t, tc.remote, netip.MustParseAddrPort(addr.String()).Addr().String())
+
+			_, err = pc.WriteTo(buf[:n], addr)
+			require.NoError(t, err)
+
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+			n, err = conn.Read(buf)
+			require.NoError(t, err)
+			require.Equal(t, "Hello, world!", string(buf[:n]))
+		}
+	})
+}
+
+func TestRoutedIdentity(t *testing.T) {
 	logger := slogt.New(t)
 
-	configFile, err := os.Open(configPath)
+	serverPrivateKey, err := types.NewPrivateKey()
 	require.NoError(t, err)
-	t.Cleanup(func() {
-		require.NoError(t, configFile.Close())
-	})
 
-	conf, err := config.FromYAML(configFile)
+	gatewayPrivateKey, err := types.NewPrivateKey()
 	require.NoError(t, err)
 
-	net, err := noisysockets.NewNetwork(logger, conf)
+	serverNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "server",
+		ListenPort: 12374,
+		PrivateKey: serverPrivateKey.String(),
+		IPs:        []string{"10.19.0.1"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "gateway",
+				PublicKey: gatewayPrivateKey.PublicKey().String(),
+				// The gateway routes a subnet, in addition to its own address.
+				IPs: []string{"10.19.0.2", "10.19.1.0/24"},
+			},
+		},
+	})
 	require.NoError(t, err)
 	t.Cleanup(func() {
-		require.NoError(t, net.Close())
+		require.NoError(t, serverNet.Close())
 	})
 
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			Dial: net.Dial,
+	gatewayNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "gateway",
+		ListenPort: 12375,
+		PrivateKey: gatewayPrivateKey.String(),
+		IPs:        []string{"10.19.0.2", "10.19.1.7"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "server",
+				PublicKey: serverPrivateKey.PublicKey().String(),
+				Endpoint:  "localhost:12374",
+				IPs:       []string{"10.19.0.1"},
+			},
 		},
-	}
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, gatewayNet.Close())
+	})
 
-	resp, err := httpClient.Get("http://web")
+	pc, err := serverNet.ListenPacket("udp", ":10000")
 	require.NoError(t, err)
 	t.Cleanup(func() {
-		require.NoError(t, resp.Body.Close())
+		_ = pc.Close()
 	})
 
-	require.Equal(t, http.StatusOK, resp.StatusCode)
-}
+	for _, tc := range []struct {
+		name   string
+		local  string
+		routed bool
+	}{
+		{name: "Host Address", local: "10.19.0.2:0"},
+		{name: "Routed Address", local: "10.19.1.7:0", routed: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := gatewayNet.ListenPacket("udp", tc.local)
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				_ = conn.Close()
+			})
 
-func generateConfig(ctx context.Context, configPath string, wgC testcontainers.Container) error {
-	wgHost, err := wgC.Host(ctx)
-	if err != nil {
-		return err
-	}
+			_, err = conn.WriteTo([]byte("Hello, world!"), &stdnet.UDPAddr{IP: stdnet.ParseIP("10.19.0.1"), Port: 10000})
+			require.NoError(t, err)
 
-	wgPort, err := wgC.MappedPort(ctx, "51820/udp")
-	if err != nil {
-		return err
+			require.NoError(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+			buf := make([]byte, 1024)
+			n, addr, err := pc.ReadFrom(buf)
+			require.NoError(t, err)
+			require.Equal(t, "Hello, world!", string(buf[:n]))
+			require.NotNil(t, addr)
+
+			peer, ok := noisysockets.PeerFromAddr(addr)
+			if tc.routed {
+				require.False(t, ok)
+			} else {
+				require.True(t, ok)
+				require.Equal(t, gatewayPrivateKey.PublicKey(), peer.PublicKey)
+				require.Equal(t, "gateway", peer.Name)
+			}
+
+			// Replies can still be sent to routed addresses.
+			_, err = pc.WriteTo(buf[:n], addr)
+			require.NoError(t, err)
+
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+			n, _, err = conn.ReadFrom(buf)
+			require.NoError(t, err)
+			require.Equal(t, "Hello, world!", string(buf[:n]))
+		})
+	}
+}
+
+func TestACL(t *testing.T) {
+	logger := slogt.New(t)
+
+	serverPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	clientPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	serverNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "server",
+		ListenPort: 12354,
+		PrivateKey: serverPrivateKey.String(),
+		IPs:        []string{"10.11.0.1"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				// A short name that is also valid base64.
+				Name:      "web1",
+				PublicKey: clientPrivateKey.PublicKey().String(),
+				Endpoint:  "localhost:12355",
+				IPs:       []string{"10.11.0.2"},
+			},
+		},
+		ACL: &v1alpha1.ACLConfig{
+			DefaultAction: v1alpha1.ACLActionDeny,
+			Rules: []v1alpha1.ACLRule{
+				{
+					Action:   v1alpha1.ACLActionAllow,
+					Peers:    []string{"web1"},
+					Protocol: "tcp",
+					Ports:    []string{"80"},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, serverNet.Close())
+	})
+
+	clientNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "client",
+		ListenPort: 12355,
+		PrivateKey: clientPrivateKey.String(),
+		IPs:        []string{"10.11.0.2"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "server",
+				PublicKey: serverPrivateKey.PublicKey().String(),
+				Endpoint:  "localhost:12354",
+				IPs:       []string{"10.11.0.1"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, clientNet.Close())
+	})
+
+	for _, port := range []string{"80", "81"} {
+		lis, err := serverNet.Listen("tcp", ":"+port)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = lis.Close()
+		})
+
+		go func() {
+			for {
+				conn, err := lis.Accept()
+				if err != nil {
+					return
+				}
+				_ = conn.Close()
+			}
+		}()
+	}
+
+	t.Run("Allowed", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		conn, err := clientNet.DialContext(ctx, "tcp", "server:80")
+		require.NoError(t, err)
+		require.NoError(t, conn.Close())
+	})
+
+	t.Run("Denied", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, err := clientNet.DialContext(ctx, "tcp", "server:81")
+		require.Error(t, err)
+
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, err = clientNet.Ping(ctx, "server")
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		stats, err := serverNet.GetPeerStats(clientPrivateKey.PublicKey())
+		require.NoError(t, err)
+		require.NotZero(t, stats.DeniedConnections)
+	})
+
+	t.Run("Replies", func(t *testing.T) {
+		// Replies to connections initiated by the server are allowed.
+		pc, err := clientNet.ListenPacket("udp", ":10000")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = pc.Close()
+		})
+
+		go func() {
+			buf := make([]byte, 1024)
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteTo(buf[:n], addr)
+		}()
+
+		conn, err := serverNet.Dial("udp", "web1:10000")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+
+		_, err = conn.Write([]byte("Hello, world!"))
+		require.NoError(t, err)
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, "Hello, world!", string(buf[:n]))
+
+		_, err = serverNet.Ping(context.Background(), "web1")
+		require.NoError(t, err)
+	})
+}
+
+func TestReconfigure(t *testing.T) {
+	logger := slogt.New(t)
+
+	serverPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	clientPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	serverConf := &v1alpha1.Config{
+		Name:       "server",
+		ListenPort: 12349,
+		PrivateKey: serverPrivateKey.String(),
+		IPs:        []string{"10.9.0.1"},
+	}
+
+	serverNet, err := noisysockets.NewNetwork(logger, serverConf)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, serverNet.Close())
+	})
+
+	lis, err := serverNet.Listen("tcp", ":80")
+	require.NoError(t, err)
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "Hello, world!")
+		}),
+	}
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	go func() {
+		if err := srv.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Failed to serve", "error", err)
+		}
+	}()
+
+	clientConf := &v1alpha1.Config{
+		Name:       "client",
+		ListenPort: 12350,
+		PrivateKey: clientPrivateKey.String(),
+		IPs:        []string{"10.9.0.2"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "server",
+				PublicKey: serverPrivateKey.PublicKey().String(),
+				// The server will be reconfigured to listen on this port.
+				Endpoint: "localhost:12351",
+				IPs:      []string{"10.9.0.1"},
+			},
+		},
+	}
+
+	clientNet, err := noisysockets.NewNetwork(logger, clientConf)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, clientNet.Close())
+	})
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: clientNet.DialContext,
+		},
+		// Allow time for handshake retransmissions.
+		Timeout: 15 * time.Second,
+	}
+
+	t.Run("Add Peer And Change Port", func(t *testing.T) {
+		serverConf.ListenPort = 12351
+		serverConf.Peers = []v1alpha1.PeerConfig{
+			{
+				Name:      "client",
+				PublicKey: clientPrivateKey.PublicKey().String(),
+				IPs:       []string{"10.9.0.2"},
+			},
+		}
+		require.NoError(t, serverNet.Reconfigure(serverConf))
+
+		resp, err := client.Get("http://server")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Rotate Private Key", func(t *testing.T) {
+		newServerPrivateKey, err := types.NewPrivateKey()
+		require.NoError(t, err)
+
+		serverConf.PrivateKey = newServerPrivateKey.String()
+		require.NoError(t, serverNet.Reconfigure(serverConf))
+
+		clientConf.Peers[0].PublicKey = newServerPrivateKey.PublicKey().String()
+		require.NoError(t, clientNet.Reconfigure(clientConf))
+		require.Equal(t, []types.NoisePublicKey{newServerPrivateKey.PublicKey()}, clientNet.KnownPeers())
+
+		resp, err := client.Get("http://server")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Conflicting Name", func(t *testing.T) {
+		conf := *clientConf
+		conf.Name = "server"
+
+		require.Error(t, clientNet.Reconfigure(&conf))
+
+		// The previous configuration is left intact.
+		addrs, err := clientNet.Resolver().LookupHost(context.Background(), "client")
+		require.NoError(t, err)
+		require.Equal(t, []string{"10.9.0.2"}, addrs)
+
+		resp, err := client.Get("http://server")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	gatewayPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	relayPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	t.Run("Rename To Removed Peer", func(t *testing.T) {
+		conf := *clientConf
+		conf.Peers = append(slices.Clone(clientConf.Peers), v1alpha1.PeerConfig{
+			Name:      "gateway",
+			PublicKey: gatewayPrivateKey.PublicKey().String(),
+			IPs:       []string{"10.9.0.10"},
+		})
+		require.NoError(t, clientNet.Reconfigure(&conf))
+
+		// Take over the name of the peer, while removing it.
+		conf.Name = "gateway"
+		conf.Peers = clientConf.Peers
+		require.NoError(t, clientNet.Reconfigure(&conf))
+
+		addrs, err := clientNet.Resolver().LookupHost(context.Background(), "gateway")
+		require.NoError(t, err)
+		require.Equal(t, []string{"10.9.0.2"}, addrs)
+
+		require.NoError(t, clientNet.Reconfigure(clientConf))
+	})
+
+	t.Run("Swap Addresses", func(t *testing.T) {
+		conf := *clientConf
+		conf.Peers = append(slices.Clone(clientConf.Peers), v1alpha1.PeerConfig{
+			Name:      "gateway",
+			PublicKey: gatewayPrivateKey.PublicKey().String(),
+			IPs:       []string{"10.9.0.10"},
+		}, v1alpha1.PeerConfig{
+			Name:      "relay",
+			PublicKey: relayPrivateKey.PublicKey().String(),
+			IPs:       []string{"10.9.0.11"},
+		})
+		require.NoError(t, clientNet.Reconfigure(&conf))
+
+		conf.Peers[1].IPs, conf.Peers[2].IPs = conf.Peers[2].IPs, conf.Peers[1].IPs
+		require.NoError(t, clientNet.Reconfigure(&conf))
+
+		addrs, err := clientNet.Resolver().LookupHost(context.Background(), "gateway")
+		require.NoError(t, err)
+		require.Equal(t, []string{"10.9.0.11"}, addrs)
+
+		addrs, err = clientNet.Resolver().LookupHost(context.Background(), "relay")
+		require.NoError(t, err)
+		require.Equal(t, []string{"10.9.0.10"}, addrs)
+
+		require.NoError(t, clientNet.Reconfigure(clientConf))
+	})
+
+	t.Run("Change Addresses", func(t *testing.T) {
+		conf := *clientConf
+		conf.IPs = []string{"10.9.0.3"}
+
+		require.ErrorIs(t, clientNet.Reconfigure(&conf), noisysockets.ErrUnsupportedChange)
+	})
+
+	t.Run("Remove Peer", func(t *testing.T) {
+		serverConf.Peers = nil
+		require.NoError(t, serverNet.Reconfigure(serverConf))
+		require.Empty(t, serverNet.KnownPeers())
+	})
+}
+
+func TestPortForward(t *testing.T) {
+	logger := slogt.New(t)
+
+	serverPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	clientPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	// A service on the host, which will be exposed to peers by the server.
+	hostLis, err := stdnet.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "Hello, world!")
+		}),
+	}
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	go func() {
+		if err := srv.Serve(hostLis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Failed to serve", "error", err)
+		}
+	}()
+
+	serverNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "server",
+		ListenPort: 12368,
+		PrivateKey: serverPrivateKey.String(),
+		IPs:        []string{"10.16.0.1"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "client",
+				PublicKey: clientPrivateKey.PublicKey().String(),
+				IPs:       []string{"10.16.0.2"},
+			},
+		},
+		PortForwards: []v1alpha1.PortForwardConfig{
+			{
+				Name:          "web",
+				Direction:     v1alpha1.PortForwardDirectionInbound,
+				ListenAddress: ":8080",
+				TargetAddress: hostLis.Addr().String(),
+			},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, serverNet.Close())
+	})
+
+	clientConf := &v1alpha1.Config{
+		Name:       "client",
+		ListenPort: 12369,
+		PrivateKey: clientPrivateKey.String(),
+		IPs:        []string{"10.16.0.2"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "server",
+				PublicKey: serverPrivateKey.PublicKey().String(),
+				Endpoint:  "localhost:12368",
+				IPs:       []string{"10.16.0.1"},
+			},
+		},
+	}
+
+	clientNet, err := noisysockets.NewNetwork(logger, clientConf)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, clientNet.Close())
+	})
+
+	// Expose the service on the server to the host.
+	require.NoError(t, clientNet.AddPortForward(v1alpha1.PortForwardConfig{
+		Name:          "web",
+		Direction:     v1alpha1.PortForwardDirectionOutbound,
+		ListenAddress: "127.0.0.1:0",
+		TargetAddress: "server:8080",
+	}))
+
+	require.ErrorIs(t, clientNet.AddPortForward(v1alpha1.PortForwardConfig{
+		Name:          "web",
+		Direction:     v1alpha1.PortForwardDirectionOutbound,
+		ListenAddress: "127.0.0.1:0",
+		TargetAddress: "server:8080",
+	}), noisysockets.ErrPortForwardExists)
+
+	stats, err := clientNet.GetPortForwardStats("web")
+	require.NoError(t, err)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+		},
+	}
+
+	resp, err := client.Get("http://" + stats.Addr.String())
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "Hello, world!", string(body))
+
+	for _, net := range []*noisysockets.NoisySocketsNetwork{clientNet, serverNet} {
+		require.Eventually(t, func() bool {
+			stats, err := net.GetPortForwardStats("web")
+			require.NoError(t, err)
+
+			return stats.TotalSessions == 1 && stats.ActiveSessions == 0 &&
+				stats.BytesSent > 0 && stats.BytesReceived > 0
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	// Port forwards not in the config are removed.
+	require.NoError(t, clientNet.Reconfigure(clientConf))
+
+	_, err = clientNet.GetPortForwardStats("web")
+	require.ErrorIs(t, err, noisysockets.ErrUnknownPortForward)
+	require.Len(t, serverNet.GetAllPortForwardStats(), 1)
+}
+
+func TestNameServer(t *testing.T) {
+	logger := slogt.New(t)
+
+	serverPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	clientPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	serverNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "server",
+		ListenPort: 12370,
+		PrivateKey: serverPrivateKey.String(),
+		IPs:        []string{"10.17.0.1"},
+		// Queries outside of the zone are forwarded to the client.
+		DNSServers: []string{"10.17.0.2"},
+		NameServer: &v1alpha1.NameServerConfig{
+			Zone: "example.internal",
+		},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "client",
+				PublicKey: clientPrivateKey.PublicKey().String(),
+				IPs:       []string{"10.17.0.2"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, serverNet.Close())
+	})
+
+	clientNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "client",
+		ListenPort: 12371,
+		PrivateKey: clientPrivateKey.String(),
+		IPs:        []string{"10.17.0.2"},
+		NameServer: &v1alpha1.NameServerConfig{},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "server",
+				PublicKey: serverPrivateKey.PublicKey().String(),
+				Endpoint:  "localhost:12370",
+				IPs:       []string{"10.17.0.1"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, clientNet.Close())
+	})
+
+	query := func(t *testing.T, network, name string, qtype uint16) *dns.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		t.Cleanup(cancel)
+
+		conn, err := clientNet.DialContext(ctx, network, "server:53")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+
+		client := &dns.Client{Net: network}
+
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, qtype)
+
+		resp, _, err := client.ExchangeWithConnContext(ctx, msg, &dns.Conn{Conn: conn})
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	t.Run("A", func(t *testing.T) {
+		for _, network := range []string{"udp", "tcp"} {
+			resp := query(t, network, "client.example.internal.", dns.TypeA)
+			require.Equal(t, dns.RcodeSuccess, resp.Rcode)
+			require.True(t, resp.Authoritative)
+			require.Len(t, resp.Answer, 1)
+			require.Equal(t, "10.17.0.2", resp.Answer[0].(*dns.A).A.String())
+		}
+	})
+
+	t.Run("AAAA", func(t *testing.T) {
+		resp := query(t, "udp", "server.example.internal.", dns.TypeAAAA)
+		require.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		require.Empty(t, resp.Answer)
+		require.Len(t, resp.Ns, 1)
+	})
+
+	t.Run("PTR", func(t *testing.T) {
+		resp := query(t, "udp", "2.0.17.10.in-addr.arpa.", dns.TypePTR)
+		require.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		require.Len(t, resp.Answer, 1)
+		require.Equal(t, "client.example.internal.", resp.Answer[0].(*dns.PTR).Ptr)
+	})
+
+	t.Run("Unknown Peer", func(t *testing.T) {
+		resp := query(t, "udp", "unknown.example.internal.", dns.TypeA)
+		require.Equal(t, dns.RcodeNameError, resp.Rcode)
+	})
+
+	t.Run("Forwarded", func(t *testing.T) {
+		// Answered by the client, which uses the default zone.
+		resp := query(t, "udp", "server.internal.", dns.TypeA)
+		require.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		require.Len(t, resp.Answer, 1)
+		require.Equal(t, "10.17.0.1", resp.Answer[0].(*dns.A).A.String())
+
+		// The client has no upstream DNS servers, so refuses the query.
+		resp = query(t, "udp", "example.com.", dns.TypeA)
+		require.Equal(t, dns.RcodeServerFailure, resp.Rcode)
+	})
+}
+
+func TestResolver(t *testing.T) {
+	logger := slogt.New(t)
+
+	serverPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	clientPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	serverNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "server",
+		ListenPort: 12372,
+		PrivateKey: serverPrivateKey.String(),
+		IPs:        []string{"10.18.0.1"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "client",
+				PublicKey: clientPrivateKey.PublicKey().String(),
+				IPs:       []string{"10.18.0.2"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, serverNet.Close())
+	})
+
+	clientNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "client",
+		ListenPort: 12373,
+		PrivateKey: clientPrivateKey.String(),
+		IPs:        []string{"10.18.0.2"},
+		DNSServers: []string{"10.18.0.1"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "server",
+				PublicKey: serverPrivateKey.PublicKey().String(),
+				Endpoint:  "localhost:12372",
+				IPs:       []string{"10.18.0.1"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, clientNet.Close())
+	})
+
+	// A DNS server, only reachable through the tunnel.
+	records := make(map[dns.Question][]dns.RR)
+	zp := dns.NewZoneParser(strings.NewReader(`$ORIGIN example.com.
+$TTL 60
+@           IN MX    20 mx2
+@           IN MX    10 mx1
+@           IN TXT   "v=spf1 " "-all"
+alias       IN CNAME www
+www         IN CNAME web
+web         IN A     192.168.1.2
+_http._tcp  IN SRV   10 0 80 web
+_http._tcp  IN SRV   5 0 8080 web
+3.1.168.192.in-addr.arpa. IN PTR web.example.com.
+`), "", "")
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		q := dns.Question{Name: rr.Header().Name, Qtype: rr.Header().Rrtype, Qclass: dns.ClassINET}
+		records[q] = append(records[q], rr)
+	}
+	require.NoError(t, zp.Err())
+
+	pc, err := serverNet.ListenPacket("udp", ":53")
+	require.NoError(t, err)
+
+	srv := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg).SetReply(r)
+
+		q := r.Question[0]
+		q.Name = dns.CanonicalName(q.Name)
+
+		// Follow CNAMEs.
+		for {
+			cname := dns.Question{Name: q.Name, Qtype: dns.TypeCNAME, Qclass: dns.ClassINET}
+			if rrs, ok := records[cname]; ok && q.Qtype != dns.TypeCNAME {
+				resp.Answer = append(resp.Answer, rrs...)
+				q.Name = rrs[0].(*dns.CNAME).Target
+				continue
+			}
+			break
+		}
+
+		resp.Answer = append(resp.Answer, records[q]...)
+		if len(resp.Answer) == 0 {
+			// Names that exist with other types of records have no data.
+			resp.Rcode = dns.RcodeNameError
+			for other := range records {
+				if other.Name == q.Name {
+					resp.Rcode = dns.RcodeSuccess
+					break
+				}
+			}
+		}
+
+		assert.NoError(t, w.WriteMsg(resp))
+	})}
+	go func() {
+		_ = srv.ActivateAndServe()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	resolver := clientNet.Resolver()
+
+	t.Run("LookupIP", func(t *testing.T) {
+		ips, err := resolver.LookupIP(ctx, "ip", "www.example.com")
+		require.NoError(t, err)
+		require.Len(t, ips, 1)
+		require.Equal(t, "192.168.1.2", ips[0].String())
+
+		// Peer names are resolved using the peer directory.
+		ips, err = resolver.LookupIP(ctx, "ip4", "server")
+		require.NoError(t, err)
+		require.Len(t, ips, 1)
+		require.Equal(t, "10.18.0.1", ips[0].String())
+
+		_, err = resolver.LookupIP(ctx, "ip6", "server")
+		var dnsErr *stdnet.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+	})
+
+	t.Run("LookupCNAME", func(t *testing.T) {
+		cname, err := resolver.LookupCNAME(ctx, "www.example.com")
+		require.NoError(t, err)
+		require.Equal(t, "web.example.com.", cname)
+
+		cname, err = resolver.LookupCNAME(ctx, "alias.example.com")
+		require.NoError(t, err)
+		require.Equal(t, "web.example.com.", cname)
+
+		cname, err = resolver.LookupCNAME(ctx, "web.example.com")
+		require.NoError(t, err)
+		require.Equal(t, "web.example.com.", cname)
+	})
+
+	t.Run("LookupSRV", func(t *testing.T) {
+		cname, srvs, err := resolver.LookupSRV(ctx, "http", "tcp", "example.com")
+		require.NoError(t, err)
+		require.Equal(t, "_http._tcp.example.com.", cname)
+		require.Len(t, srvs, 2)
+
+		require.Equal(t, uint16(8080), srvs[0].Port)
+		require.Equal(t, uint16(80), srvs[1].Port)
+		require.Equal(t, "web.example.com.", srvs[0].Target)
+	})
+
+	t.Run("LookupTXT", func(t *testing.T) {
+		txts, err := resolver.LookupTXT(ctx, "example.com")
+		require.NoError(t, err)
+		require.Equal(t, []string{"v=spf1 -all"}, txts)
+	})
+
+	t.Run("LookupMX", func(t *testing.T) {
+		mxs, err := resolver.LookupMX(ctx, "example.com")
+		require.NoError(t, err)
+		require.Len(t, mxs, 2)
+
+		require.Equal(t, "mx1.example.com.", mxs[0].Host)
+		require.Equal(t, uint16(10), mxs[0].Pref)
+		require.Equal(t, "mx2.example.com.", mxs[1].Host)
+	})
+
+	t.Run("LookupAddr", func(t *testing.T) {
+		names, err := resolver.LookupAddr(ctx, "192.168.1.2")
+		require.Error(t, err)
+		require.Empty(t, names)
+
+		names, err = resolver.LookupAddr(ctx, "192.168.1.3")
+		require.NoError(t, err)
+		require.Equal(t, []string{"web.example.com."}, names)
+
+		// Peer addresses are resolved using the peer directory.
+		names, err = resolver.LookupAddr(ctx, "10.18.0.1")
+		require.NoError(t, err)
+		require.Equal(t, []string{"server"}, names)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		// Both names that don't exist, and names without records of the
+		// requested type.
+		for _, name := range []string{"missing.example.com", "web.example.com"} {
+			lookups := map[string]func() error{
+				"TXT": func() error {
+					_, err := resolver.LookupTXT(ctx, name)
+					return err
+				},
+				"MX": func() error {
+					_, err := resolver.LookupMX(ctx, name)
+					return err
+				},
+				"SRV": func() error {
+					_, _, err := resolver.LookupSRV(ctx, "", "", name)
+					return err
+				},
+			}
+
+			for qtype, lookup := range lookups {
+				var dnsErr *stdnet.DNSError
+				require.ErrorAs(t, lookup(), &dnsErr, "%s %s", qtype, name)
+				require.True(t, dnsErr.IsNotFound, "%s %s", qtype, name)
+			}
+		}
+
+		_, err := resolver.LookupCNAME(ctx, "missing.example.com")
+		var dnsErr *stdnet.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+	})
+}
+
+func TestWireGuardCompatibility(t *testing.T) {
+	pwd, err := os.Getwd()
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	testNet, err := network.New(ctx, network.WithCheckDuplicate())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, testNet.Remove(ctx))
+	})
+
+	nginxReq := testcontainers.ContainerRequest{
+		Image:        "nginx:latest",
+		ExposedPorts: []string{"80/tcp"},
+		Networks:     []string{testNet.Name},
+		NetworkAliases: map[string][]string{
+			testNet.Name: {"web"},
+		},
+		WaitingFor: wait.ForListeningPort("80/tcp"),
+	}
+
+	nginxC, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: nginxReq,
+		Started:          true,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, nginxC.Terminate(ctx))
+	})
+
+	// Spin up a WireGuard gateway.
+	wgReq := testcontainers.ContainerRequest{
+		Image:        "ghcr.io/noisysockets/gateway:v0.1.0",
+		ExposedPorts: []string{"51820/udp"},
+		Files: []testcontainers.ContainerFile{
+			{HostFilePath: filepath.Join(pwd, "testdata/wg0.conf"), ContainerFilePath: "/etc/wireguard/wg0.conf", FileMode: 0o400},
+		},
+		Networks: []string{testNet.Name},
+		HostConfigModifier: func(hostConfig *container.HostConfig) {
+			hostConfig.CapAdd = []string{"NET_ADMIN"}
+
+			hostConfig.Sysctls = map[string]string{
+				"net.ipv4.ip_forward":              "1",
+				"net.ipv4.conf.all.src_valid_mark": "1",
+			}
+
+			hostConfig.Binds = append(hostConfig.Binds, "/dev/net/tun:/dev/net/tun")
+		},
+	}
+
+	wgC, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: wgReq,
+		Started:          true,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, wgC.Terminate(ctx))
+	})
+
+	outputDir := t.TempDir()
+	configPath := filepath.Join(outputDir, "noisysockets.yaml")
+
+	require.NoError(t, generateConfig(ctx, configPath, wgC))
+
+	logger := slogt.New(t)
+
+	configFile, err := os.Open(configPath)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, configFile.Close())
+	})
+
+	conf, err := config.FromYAML(configFile)
+	require.NoError(t, err)
+
+	net, err := noisysockets.NewNetwork(logger, conf)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, net.Close())
+	})
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			Dial: net.Dial,
+		},
+	}
+
+	resp, err := httpClient.Get("http://web")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, resp.Body.Close())
+	})
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func generateConfig(ctx context.Context, configPath string, wgC testcontainers.Container) error {
+	wgHost, err := wgC.Host(ctx)
+	if err != nil {
+		return err
+	}
+
+	wgPort, err := wgC.MappedPort(ctx, "51820/udp")
+	if err != nil {
+		return err
 	}
 
 	var renderedConfig strings.Builder
@@ -314,3 +1612,20 @@ func generateConfig(ctx context.Context, configPath string, wgC testcontainers.C
 
 	return os.WriteFile(configPath, []byte(renderedConfig.String()), 0o400)
 }
+
+// waitForEvent discards events until one of the given type is received.
+func waitForEvent(t *testing.T, events <-chan noisysockets.Event, eventType noisysockets.EventType) noisysockets.Event {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Type == eventType {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s event", eventType)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package noisysockets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	stdnet "net"
+	"sync"
+	"time"
+
+	"github.com/noisysockets/noisysockets/config/v1alpha1"
+	"github.com/noisysockets/noisysockets/forwarder"
+	"github.com/noisysockets/noisysockets/network"
+)
+
+// portForwardShutdownTimeout is how long a removed port forward waits for
+// active connections to end, before they are closed.
+const portForwardShutdownTimeout = 10 * time.Second
+
+var (
+	ErrPortForwardExists  = errors.New("port forward already exists")
+	ErrUnknownPortForward = errors.New("unknown port forward")
+)
+
+// PortForwardStats contains runtime statistics for a port forward.
+type PortForwardStats struct {
+	forwarder.Stats
+	// Name is the name of the port forward.
+	Name string
+	// Addr is the address the port forward is listening on.
+	Addr stdnet.Addr
+}
+
+type portForward struct {
+	conf v1alpha1.PortForwardConfig
+	fwd  *forwarder.Forwarder
+}
+
+// AddPortForward starts forwarding a port between the host and the network.
+func (net *NoisySocketsNetwork) AddPortForward(conf v1alpha1.PortForwardConfig) error {
+	net.portForwardsMu.Lock()
+	defer net.portForwardsMu.Unlock()
+
+	return net.addPortForwardLocked(conf)
+}
+
+func (net *NoisySocketsNetwork) addPortForwardLocked(conf v1alpha1.PortForwardConfig) error {
+	if _, ok := net.portForwards[conf.Name]; ok {
+		return ErrPortForwardExists
+	}
+
+	fwdConf := forwarder.Config{
+		Protocol:       conf.Protocol,
+		ListenAddress:  conf.ListenAddress,
+		TargetAddress:  conf.TargetAddress,
+		UDPIdleTimeout: conf.UDPIdleTimeout,
+	}
+
+	if fwdConf.Protocol == "" {
+		fwdConf.Protocol = "tcp"
+	}
+
+	switch conf.Direction {
+	case v1alpha1.PortForwardDirectionInbound:
+		fwdConf.ListenNetwork = net
+		fwdConf.TargetNetwork = network.Host()
+	case v1alpha1.PortForwardDirectionOutbound:
+		fwdConf.ListenNetwork = network.Host()
+		fwdConf.TargetNetwork = net
+	default:
+		return fmt.Errorf("unsupported direction: %s", conf.Direction)
+	}
+
+	fwd, err := forwarder.New(net.logger.With(slog.String("portForward", conf.Name)), fwdConf)
+	if err != nil {
+		return err
+	}
+
+	net.portForwards[conf.Name] = &portForward{conf: conf, fwd: fwd}
+
+	return nil
+}
+
+// RemovePortForward stops forwarding a port. Active connections are given a
+// short grace period to end before they are closed.
+func (net *NoisySocketsNetwork) RemovePortForward(name string) error {
+	net.portForwardsMu.Lock()
+	pf, ok := net.portForwards[name]
+	if ok {
+		delete(net.portForwards, name)
+	}
+	net.portForwardsMu.Unlock()
+
+	if !ok {
+		return ErrUnknownPortForward
+	}
+
+	return shutdownPortForwards([]*portForward{pf})
+}
+
+// shutdownPortForwards gracefully shuts down removed port forwards. It waits
+// for active connections to end, so must not be called with any locks held.
+func shutdownPortForwards(pfs []*portForward) error {
+	ctx, cancel := context.WithTimeout(context.Background(), portForwardShutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(pfs))
+	for i, pf := range pfs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := pf.fwd.Shutdown(ctx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+				errs[i] = fmt.Errorf("failed to remove port forward %s: %w", pf.conf.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// GetPortForwardStats returns runtime statistics for a port forward.
+func (net *NoisySocketsNetwork) GetPortForwardStats(name string) (*PortForwardStats, error) {
+	net.portForwardsMu.Lock()
+	defer net.portForwardsMu.Unlock()
+
+	pf, ok := net.portForwards[name]
+	if !ok {
+		return nil, ErrUnknownPortForward
+	}
+
+	return &PortForwardStats{
+		Stats: pf.fwd.Stats(),
+		Name:  name,
+		Addr:  pf.fwd.Addr(),
+	}, nil
+}
+
+// GetAllPortForwardStats returns runtime statistics for all port forwards,
+// keyed by name.
+func (net *NoisySocketsNetwork) GetAllPortForwardStats() map[string]PortForwardStats {
+	net.portForwardsMu.Lock()
+	defer net.portForwardsMu.Unlock()
+
+	allStats := make(map[string]PortForwardStats)
+	for name, pf := range net.portForwards {
+		allStats[name] = PortForwardStats{
+			Stats: pf.fwd.Stats(),
+			Name:  name,
+			Addr:  pf.fwd.Addr(),
+		}
+	}
+
+	return allStats
+}
+
+// reconcilePortForwards adds, replaces and removes port forwards to match the
+// given configuration. Unchanged port forwards are left running.
+func (net *NoisySocketsNetwork) reconcilePortForwards(confs []v1alpha1.PortForwardConfig) error {
+	wantPortForwards := make(map[string]v1alpha1.PortForwardConfig)
+	for _, conf := range confs {
+		wantPortForwards[conf.Name] = conf
+	}
+
+	net.portForwardsMu.Lock()
+	var removed []*portForward
+	for name, pf := range net.portForwards {
+		if conf, ok := wantPortForwards[name]; !ok || conf != pf.conf {
+			delete(net.portForwards, name)
+			removed = append(removed, pf)
+		}
+	}
+	net.portForwardsMu.Unlock()
+
+	// Shut down removed port forwards first, so that their addresses can be
+	// reused.
+	if err := shutdownPortForwards(removed); err != nil {
+		return err
+	}
+
+	net.portForwardsMu.Lock()
+	defer net.portForwardsMu.Unlock()
+
+	for _, conf := range confs {
+		if _, ok := net.portForwards[conf.Name]; ok {
+			continue
+		}
+
+		if err := net.addPortForwardLocked(conf); err != nil {
+			return fmt.Errorf("failed to add port forward %s: %w", conf.Name, err)
+		}
+	}
+
+	return nil
+}
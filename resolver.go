@@ -0,0 +1,331 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package noisysockets
+
+import (
+	"cmp"
+	"context"
+	"math/rand/v2"
+	stdnet "net"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/noisysockets/internal/dns/addrselect"
+)
+
+// maxCNAMEChain bounds the length of the chain of CNAME records followed by
+// LookupCNAME, to avoid loops.
+const maxCNAMEChain = 8
+
+// Resolver looks up names and numbers, it is the equivalent of net.Resolver
+// for the network. Peer names (and addresses) are resolved using the peer
+// directory, everything else is looked up using the configured DNS servers.
+type Resolver struct {
+	net *NoisySocketsNetwork
+}
+
+// Resolver returns a resolver for the network.
+func (net *NoisySocketsNetwork) Resolver() *Resolver {
+	return &Resolver{net: net}
+}
+
+// LookupHost looks up the given host, returning a slice of its addresses.
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	addrs, err := r.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	addrsStrings := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		addrsStrings = append(addrsStrings, addr.String())
+	}
+
+	return addrsStrings, nil
+}
+
+// LookupIP looks up host for the given network, which must be "ip", "ip4"
+// or "ip6".
+func (r *Resolver) LookupIP(ctx context.Context, network, host string) ([]stdnet.IP, error) {
+	addrs, err := r.LookupNetIP(ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]stdnet.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.AsSlice())
+	}
+
+	return ips, nil
+}
+
+// LookupNetIP is like LookupIP but returns netip.Addr values.
+func (r *Resolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	var wantV4, wantV6 bool
+	switch network {
+	case "ip":
+		wantV4, wantV6 = r.net.hasV4, r.net.hasV6
+	case "ip4":
+		wantV4 = r.net.hasV4
+	case "ip6":
+		wantV6 = r.net.hasV6
+	default:
+		return nil, stdnet.UnknownNetworkError(network)
+	}
+
+	var addrs []netip.Addr
+
+	// Host is an IP address.
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if (addr.Is4() && network != "ip6") || (addr.Is6() && network != "ip4") {
+			return []netip.Addr{addr}, nil
+		}
+
+		return nil, &stdnet.DNSError{Err: "no suitable address", Name: host}
+	}
+
+	// Host is the name of a peer.
+	if peerAddrs, ok := r.net.pd.LookupPeerAddressesByName(host); ok {
+		addrs = peerAddrs
+	} else if len(r.net.getDNSServers()) > 0 {
+		// Host is a DNS name.
+		var err error
+		addrs, err = r.net.resolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	addrs = slices.DeleteFunc(slices.Clone(addrs), func(addr netip.Addr) bool {
+		return !(wantV4 && addr.Is4()) && !(wantV6 && addr.Is6())
+	})
+
+	if len(addrs) == 0 {
+		return nil, &stdnet.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	addrselect.SortByRFC6724(r.net, addrs)
+
+	return addrs, nil
+}
+
+// LookupAddr performs a reverse lookup for the given address, returning a
+// list of names mapping to that address. The addresses of peers are mapped to
+// their peer name.
+func (r *Resolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return nil, &stdnet.DNSError{Err: "unrecognized address", Name: addr}
+	}
+
+	if name, ok := r.net.pd.LookupPeerNameByAddress(ip.Unmap()); ok && name != "" {
+		return []string{name}, nil
+	}
+
+	reverseName, err := dns.ReverseAddr(ip.Unmap().String())
+	if err != nil {
+		return nil, &stdnet.DNSError{Err: err.Error(), Name: addr}
+	}
+
+	resp, err := r.query(ctx, reverseName, dns.TypePTR)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, rr := range resp.Answer {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			names = append(names, ptr.Ptr)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, &stdnet.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+
+	return names, nil
+}
+
+// LookupCNAME returns the canonical name for the given host, following any
+// chain of CNAME records. If the host has no CNAME records, the host itself
+// (as a fully qualified name) is returned.
+func (r *Resolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	if _, ok := r.net.pd.LookupPeerAddressesByName(host); ok {
+		return dns.Fqdn(host), nil
+	}
+
+	cname := dns.Fqdn(host)
+
+	for range maxCNAMEChain {
+		resp, err := r.query(ctx, cname, dns.TypeCNAME)
+		if err != nil {
+			return "", err
+		}
+
+		var found bool
+		for _, rr := range resp.Answer {
+			if rr, ok := rr.(*dns.CNAME); ok && strings.EqualFold(rr.Hdr.Name, cname) {
+				cname, found = rr.Target, true
+				break
+			}
+		}
+
+		// The end of the chain.
+		if !found {
+			return cname, nil
+		}
+	}
+
+	return "", &stdnet.DNSError{Err: "too many CNAME records", Name: host}
+}
+
+// LookupMX returns the DNS MX records for the given domain name, sorted by
+// preference.
+func (r *Resolver) LookupMX(ctx context.Context, name string) ([]*stdnet.MX, error) {
+	resp, err := r.query(ctx, name, dns.TypeMX)
+	if err != nil {
+		return nil, err
+	}
+
+	var mxs []*stdnet.MX
+	for _, rr := range resp.Answer {
+		if rr, ok := rr.(*dns.MX); ok {
+			mxs = append(mxs, &stdnet.MX{Host: rr.Mx, Pref: rr.Preference})
+		}
+	}
+
+	if len(mxs) == 0 {
+		return nil, &stdnet.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+
+	slices.SortStableFunc(mxs, func(a, b *stdnet.MX) int {
+		return cmp.Compare(a.Pref, b.Pref)
+	})
+
+	return mxs, nil
+}
+
+// LookupSRV looks up the SRV records for the given service, protocol and
+// domain name. If service and proto are empty, name is looked up directly.
+// The returned records are sorted by priority, and randomized by weight
+// within a priority (RFC 2782).
+func (r *Resolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*stdnet.SRV, error) {
+	target := name
+	if service != "" || proto != "" {
+		target = "_" + service + "._" + proto + "." + name
+	}
+
+	resp, err := r.query(ctx, target, dns.TypeSRV)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var srvs []*stdnet.SRV
+	for _, rr := range resp.Answer {
+		if rr, ok := rr.(*dns.SRV); ok {
+			srvs = append(srvs, &stdnet.SRV{
+				Target:   rr.Target,
+				Port:     rr.Port,
+				Priority: rr.Priority,
+				Weight:   rr.Weight,
+			})
+		}
+	}
+
+	if len(srvs) == 0 {
+		return "", nil, &stdnet.DNSError{Err: "no such host", Name: target, IsNotFound: true}
+	}
+
+	sortSRVs(srvs)
+
+	return dns.Fqdn(target), srvs, nil
+}
+
+// LookupTXT returns the DNS TXT records for the given domain name. The
+// strings of each record are concatenated.
+func (r *Resolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	resp, err := r.query(ctx, name, dns.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+
+	var txts []string
+	for _, rr := range resp.Answer {
+		if rr, ok := rr.(*dns.TXT); ok {
+			txts = append(txts, strings.Join(rr.Txt, ""))
+		}
+	}
+
+	if len(txts) == 0 {
+		return nil, &stdnet.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+
+	return txts, nil
+}
+
+// query looks up records of the given type for a name, using the configured
+// DNS servers.
+func (r *Resolver) query(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	if len(r.net.getDNSServers()) == 0 {
+		return nil, &stdnet.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+
+	resp, err := r.net.resolver.Query(ctx, name, qtype)
+	if err != nil {
+		return nil, &stdnet.DNSError{Err: err.Error(), Name: name}
+	}
+
+	if resp.Rcode == dns.RcodeNameError {
+		return nil, &stdnet.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+
+	return resp, nil
+}
+
+// sortSRVs sorts the records by priority, and within a priority orders them
+// randomly with the probability of a record being picked next proportional
+// to its weight (RFC 2782).
+func sortSRVs(srvs []*stdnet.SRV) {
+	slices.SortStableFunc(srvs, func(a, b *stdnet.SRV) int {
+		return cmp.Compare(a.Priority, b.Priority)
+	})
+
+	for start := 0; start < len(srvs); {
+		end := start + 1
+		for end < len(srvs) && srvs[end].Priority == srvs[start].Priority {
+			end++
+		}
+
+		group := srvs[start:end]
+		for i := range group {
+			var total int
+			for _, srv := range group[i:] {
+				total += int(srv.Weight)
+			}
+			if total == 0 {
+				break
+			}
+
+			pick := rand.IntN(total)
+			for j, srv := range group[i:] {
+				pick -= int(srv.Weight)
+				if pick < 0 {
+					group[i], group[i+j] = group[i+j], group[i]
+					break
+				}
+			}
+		}
+
+		start = end
+	}
+}
@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package socks5
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	stdnet "net"
+	"net/netip"
+	"strconv"
+)
+
+// See RFC 1928 and RFC 1929.
+const (
+	socksVersion = 0x05
+
+	authNone         = 0x00
+	authPassword     = 0x02
+	authNoAcceptable = 0xff
+
+	passwordAuthVersion = 0x01
+	passwordAuthSuccess = 0x00
+	passwordAuthFailure = 0x01
+
+	cmdConnect      = 0x01
+	cmdUDPAssociate = 0x03
+
+	addrTypeIPv4   = 0x01
+	addrTypeDomain = 0x03
+	addrTypeIPv6   = 0x04
+
+	replySuccess              = 0x00
+	replyGeneralFailure       = 0x01
+	replyNotAllowed           = 0x02
+	replyNetworkUnreachable   = 0x03
+	replyHostUnreachable      = 0x04
+	replyConnectionRefused    = 0x05
+	replyCommandNotSupported  = 0x07
+	replyAddrTypeNotSupported = 0x08
+)
+
+var errAddrTypeNotSupported = errors.New("address type not supported")
+
+// unspecifiedAddr is the bind address of failure replies, and of replies
+// where the bind address is unknown.
+var unspecifiedAddr = netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
+
+// address is a SOCKS address, either a domain name or an IP address.
+type address struct {
+	host string
+	port uint16
+}
+
+func (a address) String() string {
+	return stdnet.JoinHostPort(a.host, strconv.Itoa(int(a.port)))
+}
+
+// readAddress reads an address (ATYP, DST.ADDR, DST.PORT) from r.
+func readAddress(r io.Reader) (address, error) {
+	var addrType [1]byte
+	if _, err := io.ReadFull(r, addrType[:]); err != nil {
+		return address{}, err
+	}
+
+	var addr address
+	switch addrType[0] {
+	case addrTypeIPv4:
+		var ip [4]byte
+		if _, err := io.ReadFull(r, ip[:]); err != nil {
+			return address{}, err
+		}
+		addr.host = netip.AddrFrom4(ip).String()
+	case addrTypeIPv6:
+		var ip [16]byte
+		if _, err := io.ReadFull(r, ip[:]); err != nil {
+			return address{}, err
+		}
+		addr.host = netip.AddrFrom16(ip).String()
+	case addrTypeDomain:
+		var length [1]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return address{}, err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return address{}, err
+		}
+		addr.host = string(domain)
+	default:
+		return address{}, errAddrTypeNotSupported
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return address{}, err
+	}
+	addr.port = binary.BigEndian.Uint16(port[:])
+
+	return addr, nil
+}
+
+// appendAddress appends the encoded address (ATYP, ADDR, PORT) to b.
+func appendAddress(b []byte, addr netip.AddrPort) []byte {
+	ip := addr.Addr().Unmap()
+	if ip.Is4() {
+		b = append(b, addrTypeIPv4)
+	} else {
+		b = append(b, addrTypeIPv6)
+	}
+	b = append(b, ip.AsSlice()...)
+	return binary.BigEndian.AppendUint16(b, addr.Port())
+}
+
+// addrPortFromNetAddr converts a net.Addr into a netip.AddrPort.
+func addrPortFromNetAddr(addr stdnet.Addr) netip.AddrPort {
+	if addr == nil {
+		return unspecifiedAddr
+	}
+
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return unspecifiedAddr
+	}
+
+	return addrPort
+}
+
+// writeReply writes a reply to a request.
+func writeReply(w io.Writer, reply byte, bindAddr netip.AddrPort) error {
+	b := []byte{socksVersion, reply, 0x00}
+	b = appendAddress(b, bindAddr)
+
+	if _, err := w.Write(b); err != nil {
+		return fmt.Errorf("failed to write reply: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,377 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package socks5 provides a SOCKS5 proxy server that forwards connections
+// over a network.Network, so that programs which can't link the library can
+// still reach hosts on the noisy network.
+package socks5
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	stdnet "net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/noisysockets/noisysockets/network"
+)
+
+// handshakeTimeout bounds how long a client has to send its request.
+const handshakeTimeout = 30 * time.Second
+
+// ErrServerClosed is returned by Serve after the server has been closed.
+var ErrServerClosed = errors.New("socks5: server closed")
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithCredentials requires clients to authenticate with the given username
+// and password.
+func WithCredentials(username, password string) Option {
+	return func(s *Server) {
+		s.username = username
+		s.password = password
+		s.requireAuth = true
+	}
+}
+
+// Server is a SOCKS5 proxy server supporting the CONNECT and UDP ASSOCIATE
+// commands. Connections are made, and hostnames resolved, using the
+// configured network.
+type Server struct {
+	logger      *slog.Logger
+	net         network.Network
+	requireAuth bool
+	username    string
+	password    string
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[stdnet.Listener]struct{}
+	conns     map[stdnet.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// NewServer creates a new SOCKS5 server that forwards connections over the
+// given network.
+func NewServer(logger *slog.Logger, net network.Network, opts ...Option) *Server {
+	s := &Server{
+		logger:    logger,
+		net:       net,
+		listeners: make(map[stdnet.Listener]struct{}),
+		conns:     make(map[stdnet.Conn]struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// ListenAndServe listens on the given TCP address of the host network and
+// serves SOCKS5 requests.
+func (s *Server) ListenAndServe(address string) error {
+	lis, err := stdnet.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(lis)
+}
+
+// Serve accepts connections on the listener and serves SOCKS5 requests. It
+// always returns a non-nil error, after Close it returns ErrServerClosed.
+func (s *Server) Serve(lis stdnet.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = lis.Close()
+		return ErrServerClosed
+	}
+	s.listeners[lis] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, lis)
+		s.mu.Unlock()
+	}()
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+
+			if closed {
+				return ErrServerClosed
+			}
+
+			return err
+		}
+
+		if !s.trackConn(conn) {
+			_ = conn.Close()
+			return ErrServerClosed
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrackConn(conn)
+
+			if err := s.handleConn(conn); err != nil {
+				s.logger.Debug("Failed to handle SOCKS5 connection",
+					"client", conn.RemoteAddr(), "error", err)
+			}
+		}()
+	}
+}
+
+// Close immediately closes all listeners and active connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for lis := range s.listeners {
+		_ = lis.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return nil
+}
+
+func (s *Server) trackConn(conn stdnet.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackConn(conn stdnet.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+
+	_ = conn.Close()
+}
+
+func (s *Server) handleConn(conn stdnet.Conn) error {
+	if err := conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return err
+	}
+
+	if err := s.negotiateAuth(conn); err != nil {
+		return err
+	}
+
+	var hdr [3]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return fmt.Errorf("failed to read request: %w", err)
+	}
+
+	if hdr[0] != socksVersion {
+		return fmt.Errorf("unsupported version: %d", hdr[0])
+	}
+
+	dst, err := readAddress(conn)
+	if err != nil {
+		if errors.Is(err, errAddrTypeNotSupported) {
+			_ = writeReply(conn, replyAddrTypeNotSupported, unspecifiedAddr)
+		}
+		return fmt.Errorf("failed to read destination address: %w", err)
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	switch hdr[1] {
+	case cmdConnect:
+		return s.handleConnect(conn, dst)
+	case cmdUDPAssociate:
+		return s.handleUDPAssociate(conn)
+	default:
+		_ = writeReply(conn, replyCommandNotSupported, unspecifiedAddr)
+		return fmt.Errorf("unsupported command: %d", hdr[1])
+	}
+}
+
+func (s *Server) negotiateAuth(conn stdnet.Conn) error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return fmt.Errorf("failed to read greeting: %w", err)
+	}
+
+	if hdr[0] != socksVersion {
+		return fmt.Errorf("unsupported version: %d", hdr[0])
+	}
+
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return fmt.Errorf("failed to read auth methods: %w", err)
+	}
+
+	method := byte(authNone)
+	if s.requireAuth {
+		method = authPassword
+	}
+
+	supported := false
+	for _, m := range methods {
+		if m == method {
+			supported = true
+			break
+		}
+	}
+
+	if !supported {
+		_, _ = conn.Write([]byte{socksVersion, authNoAcceptable})
+		return errors.New("no acceptable auth methods")
+	}
+
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return fmt.Errorf("failed to write auth method: %w", err)
+	}
+
+	if method == authPassword {
+		return s.authenticate(conn)
+	}
+
+	return nil
+}
+
+func (s *Server) authenticate(conn stdnet.Conn) error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return fmt.Errorf("failed to read auth request: %w", err)
+	}
+
+	if hdr[0] != passwordAuthVersion {
+		return fmt.Errorf("unsupported auth version: %d", hdr[0])
+	}
+
+	username := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, username); err != nil {
+		return fmt.Errorf("failed to read username: %w", err)
+	}
+
+	var passwordLen [1]byte
+	if _, err := io.ReadFull(conn, passwordLen[:]); err != nil {
+		return fmt.Errorf("failed to read password: %w", err)
+	}
+
+	password := make([]byte, passwordLen[0])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return fmt.Errorf("failed to read password: %w", err)
+	}
+
+	usernameOK := subtle.ConstantTimeCompare(username, []byte(s.username)) == 1
+	passwordOK := subtle.ConstantTimeCompare(password, []byte(s.password)) == 1
+	if !usernameOK || !passwordOK {
+		_, _ = conn.Write([]byte{passwordAuthVersion, passwordAuthFailure})
+		return errors.New("invalid credentials")
+	}
+
+	if _, err := conn.Write([]byte{passwordAuthVersion, passwordAuthSuccess}); err != nil {
+		return fmt.Errorf("failed to write auth response: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Server) handleConnect(conn stdnet.Conn, dst address) error {
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+
+	upstream, err := s.net.DialContext(ctx, "tcp", dst.String())
+	if err != nil {
+		_ = writeReply(conn, replyForError(err), unspecifiedAddr)
+		return fmt.Errorf("failed to dial %s: %w", dst, err)
+	}
+	defer upstream.Close()
+
+	if err := writeReply(conn, replySuccess, addrPortFromNetAddr(upstream.LocalAddr())); err != nil {
+		return err
+	}
+
+	s.logger.Debug("Proxying SOCKS5 connection",
+		"client", conn.RemoteAddr(), "destination", dst.String())
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		copyAndCloseWrite(upstream, conn)
+	}()
+
+	go func() {
+		defer wg.Done()
+		copyAndCloseWrite(conn, upstream)
+	}()
+
+	wg.Wait()
+
+	return nil
+}
+
+// copyAndCloseWrite copies from src to dst, and then signals EOF to dst (or
+// closes it if half-close is not supported).
+func copyAndCloseWrite(dst, src stdnet.Conn) {
+	_, _ = io.Copy(dst, src)
+
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		if err := cw.CloseWrite(); err == nil {
+			return
+		}
+	}
+
+	_ = dst.Close()
+}
+
+// replyForError maps a dial error to a SOCKS5 reply code.
+func replyForError(err error) byte {
+	var dnsErr *stdnet.DNSError
+	if errors.As(err, &dnsErr) {
+		return replyHostUnreachable
+	}
+
+	var netErr stdnet.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return replyHostUnreachable
+	}
+
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return replyConnectionRefused
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return replyHostUnreachable
+	case errors.Is(err, syscall.ENETUNREACH):
+		return replyNetworkUnreachable
+	}
+
+	return replyGeneralFailure
+}
@@ -0,0 +1,255 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package socks5_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	stdnet "net"
+	stdhttp "net/http"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/neilotoole/slogt"
+	"github.com/noisysockets/noisysockets"
+	"github.com/noisysockets/noisysockets/config/v1alpha1"
+	"github.com/noisysockets/noisysockets/socks5"
+	"github.com/noisysockets/noisysockets/types"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+)
+
+func TestServer(t *testing.T) {
+	logger := slogt.New(t)
+
+	serverPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	clientPrivateKey, err := types.NewPrivateKey()
+	require.NoError(t, err)
+
+	serverNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "server",
+		ListenPort: 12364,
+		PrivateKey: serverPrivateKey.String(),
+		IPs:        []string{"10.14.0.1"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "client",
+				PublicKey: clientPrivateKey.PublicKey().String(),
+				IPs:       []string{"10.14.0.2"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, serverNet.Close())
+	})
+
+	clientNet, err := noisysockets.NewNetwork(logger, &v1alpha1.Config{
+		Name:       "client",
+		ListenPort: 12365,
+		PrivateKey: clientPrivateKey.String(),
+		IPs:        []string{"10.14.0.2"},
+		Peers: []v1alpha1.PeerConfig{
+			{
+				Name:      "server",
+				PublicKey: serverPrivateKey.PublicKey().String(),
+				Endpoint:  "localhost:12364",
+				IPs:       []string{"10.14.0.1"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, clientNet.Close())
+	})
+
+	lis, err := serverNet.Listen("tcp", ":80")
+	require.NoError(t, err)
+
+	srv := &stdhttp.Server{
+		Handler: stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+			fmt.Fprint(w, "Hello, world!")
+		}),
+	}
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	go func() {
+		if err := srv.Serve(lis); err != nil && !errors.Is(err, stdhttp.ErrServerClosed) {
+			logger.Error("Failed to serve", "error", err)
+		}
+	}()
+
+	pc, err := serverNet.ListenPacket("udp", ":53")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = pc.Close()
+	})
+
+	// A UDP echo server.
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			if _, err := pc.WriteTo(buf[:n], addr); err != nil {
+				return
+			}
+		}
+	}()
+
+	proxyLis, err := stdnet.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	proxySrv := socks5.NewServer(logger, clientNet, socks5.WithCredentials("user", "pass"))
+	t.Cleanup(func() {
+		require.NoError(t, proxySrv.Close())
+	})
+
+	go func() {
+		if err := proxySrv.Serve(proxyLis); err != nil && !errors.Is(err, socks5.ErrServerClosed) {
+			logger.Error("Failed to serve", "error", err)
+		}
+	}()
+
+	proxyAddr := proxyLis.Addr().String()
+
+	t.Run("Connect", func(t *testing.T) {
+		dialer, err := proxy.SOCKS5("tcp", proxyAddr, &proxy.Auth{User: "user", Password: "pass"}, proxy.Direct)
+		require.NoError(t, err)
+
+		client := &stdhttp.Client{
+			Transport: &stdhttp.Transport{
+				DialContext: dialer.(proxy.ContextDialer).DialContext,
+			},
+		}
+		t.Cleanup(client.CloseIdleConnections)
+
+		resp, err := client.Get("http://server")
+		require.NoError(t, err)
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		require.Equal(t, stdhttp.StatusOK, resp.StatusCode)
+		require.Equal(t, "Hello, world!", string(body))
+	})
+
+	t.Run("Invalid Credentials", func(t *testing.T) {
+		dialer, err := proxy.SOCKS5("tcp", proxyAddr, &proxy.Auth{User: "user", Password: "wrong"}, proxy.Direct)
+		require.NoError(t, err)
+
+		_, err = dialer.Dial("tcp", "server:80")
+		require.Error(t, err)
+	})
+
+	t.Run("Connection Refused", func(t *testing.T) {
+		ctrl := authenticate(t, proxyAddr)
+
+		// Nothing is listening on port 81.
+		_, err := ctrl.Write([]byte{0x05, 0x01, 0x00, 0x01, 10, 14, 0, 1, 0, 81})
+		require.NoError(t, err)
+
+		// Failure replies still carry a (zero) IPv4 bind address.
+		reply, err := io.ReadAll(ctrl)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0}, reply)
+	})
+
+	t.Run("UDP Associate", func(t *testing.T) {
+		ctrl := authenticate(t, proxyAddr)
+
+		// Request a UDP association.
+		_, err := ctrl.Write([]byte{0x05, 0x03, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		require.NoError(t, err)
+
+		reply := make([]byte, 10)
+		_, err = io.ReadFull(ctrl, reply)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x05, 0x00, 0x00, 0x01}, reply[:4])
+
+		relayAddr := netip.AddrPortFrom(netip.AddrFrom4([4]byte(reply[4:8])), binary.BigEndian.Uint16(reply[8:10]))
+
+		conn, err := stdnet.DialUDP("udp", nil, stdnet.UDPAddrFromAddrPort(relayAddr))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+
+		// Address the echo server by name.
+		req := []byte{0x00, 0x00, 0x00, 0x03, byte(len("server"))}
+		req = append(req, "server"...)
+		req = binary.BigEndian.AppendUint16(req, 53)
+		req = append(req, "Hello, world!"...)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		t.Cleanup(cancel)
+
+		buf := make([]byte, 1500)
+		var n int
+		for {
+			_, err = conn.Write(req)
+			require.NoError(t, err)
+
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(500*time.Millisecond)))
+
+			n, err = conn.Read(buf)
+			if err == nil || ctx.Err() != nil {
+				break
+			}
+		}
+		require.NoError(t, err)
+
+		expected := []byte{0x00, 0x00, 0x00, 0x01, 10, 14, 0, 1, 0, 53}
+		expected = append(expected, "Hello, world!"...)
+		require.True(t, bytes.Equal(expected, buf[:n]), "unexpected reply: %v", buf[:n])
+	})
+}
+
+// authenticate connects to the proxy and authenticates with a username and
+// password, returning the connection ready for a request.
+func authenticate(t *testing.T, proxyAddr string) stdnet.Conn {
+	conn, err := stdnet.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = conn.Write([]byte{0x05, 0x01, 0x02})
+	require.NoError(t, err)
+
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x05, 0x02}, reply)
+
+	_, err = conn.Write([]byte{0x01, 4, 'u', 's', 'e', 'r', 4, 'p', 'a', 's', 's'})
+	require.NoError(t, err)
+
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x01, 0x00}, reply)
+
+	return conn
+}
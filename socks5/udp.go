@@ -0,0 +1,214 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package socks5
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	stdnet "net"
+	"net/netip"
+	"sync"
+)
+
+// maxDatagramSize is the largest UDP payload we will relay.
+const maxDatagramSize = 65535
+
+// handleUDPAssociate relays datagrams between the client and the network
+// until the control connection is closed.
+func (s *Server) handleUDPAssociate(conn stdnet.Conn) error {
+	clientAddr := addrPortFromNetAddr(conn.RemoteAddr())
+	localAddr := addrPortFromNetAddr(conn.LocalAddr())
+
+	// The relay socket is opened on the host network, on the same interface
+	// that the client connected to.
+	relay, err := stdnet.ListenUDP("udp", stdnet.UDPAddrFromAddrPort(netip.AddrPortFrom(localAddr.Addr(), 0)))
+	if err != nil {
+		_ = writeReply(conn, replyGeneralFailure, unspecifiedAddr)
+		return fmt.Errorf("failed to open relay socket: %w", err)
+	}
+	defer relay.Close()
+
+	upstream, err := s.net.ListenPacket("udp", ":0")
+	if err != nil {
+		_ = writeReply(conn, replyGeneralFailure, unspecifiedAddr)
+		return fmt.Errorf("failed to open upstream socket: %w", err)
+	}
+	defer upstream.Close()
+
+	if err := writeReply(conn, replySuccess, addrPortFromNetAddr(relay.LocalAddr())); err != nil {
+		return err
+	}
+
+	s.logger.Debug("Relaying SOCKS5 datagrams",
+		"client", conn.RemoteAddr(), "relay", relay.LocalAddr())
+
+	a := &udpAssociation{
+		server:   s,
+		relay:    relay,
+		upstream: upstream,
+		clientIP: clientAddr.Addr().Unmap(),
+		resolved: make(map[string]netip.Addr),
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		a.relayOutbound()
+	}()
+
+	go func() {
+		defer wg.Done()
+		a.relayInbound()
+	}()
+
+	// The association lasts as long as the control connection.
+	_, _ = io.Copy(io.Discard, conn)
+
+	_ = relay.Close()
+	_ = upstream.Close()
+
+	wg.Wait()
+
+	return nil
+}
+
+type udpAssociation struct {
+	server   *Server
+	relay    *stdnet.UDPConn
+	upstream stdnet.PacketConn
+	// clientIP is the only address datagrams are accepted from.
+	clientIP netip.Addr
+	// resolved caches the addresses of destination domain names.
+	resolved map[string]netip.Addr
+
+	mu sync.Mutex
+	// clientAddr is the address the client sends datagrams from, it is
+	// learned from the first datagram.
+	clientAddr netip.AddrPort
+}
+
+// relayOutbound forwards datagrams from the client to the network.
+func (a *udpAssociation) relayOutbound() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, from, err := a.relay.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+
+		if from.Addr().Unmap() != a.clientIP {
+			continue
+		}
+
+		a.mu.Lock()
+		a.clientAddr = from
+		a.mu.Unlock()
+
+		dst, payload, err := parseDatagram(buf[:n])
+		if err != nil {
+			a.server.logger.Debug("Dropping SOCKS5 datagram", "client", from, "error", err)
+			continue
+		}
+
+		dstAddr, err := a.resolve(dst)
+		if err != nil {
+			a.server.logger.Debug("Failed to resolve SOCKS5 datagram destination",
+				"destination", dst.String(), "error", err)
+			continue
+		}
+
+		if _, err := a.upstream.WriteTo(payload, stdnet.UDPAddrFromAddrPort(dstAddr)); err != nil {
+			a.server.logger.Debug("Failed to forward SOCKS5 datagram",
+				"destination", dstAddr, "error", err)
+		}
+	}
+}
+
+// relayInbound forwards datagrams from the network back to the client.
+func (a *udpAssociation) relayInbound() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, from, err := a.upstream.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		a.mu.Lock()
+		clientAddr := a.clientAddr
+		a.mu.Unlock()
+
+		// We haven't heard from the client yet, so we don't know where to send it.
+		if !clientAddr.IsValid() {
+			continue
+		}
+
+		b := make([]byte, 0, n+22)
+		b = append(b, 0x00, 0x00, 0x00)
+		b = appendAddress(b, addrPortFromNetAddr(from))
+		b = append(b, buf[:n]...)
+
+		if _, err := a.relay.WriteToUDPAddrPort(b, clientAddr); err != nil {
+			a.server.logger.Debug("Failed to return SOCKS5 datagram",
+				"client", clientAddr, "error", err)
+		}
+	}
+}
+
+func (a *udpAssociation) resolve(dst address) (netip.AddrPort, error) {
+	if addr, err := netip.ParseAddr(dst.host); err == nil {
+		return netip.AddrPortFrom(addr.Unmap(), dst.port), nil
+	}
+
+	if addr, ok := a.resolved[dst.host]; ok {
+		return netip.AddrPortFrom(addr, dst.port), nil
+	}
+
+	addrs, err := a.server.net.LookupHost(dst.host)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+
+	for _, addrStr := range addrs {
+		addr, err := netip.ParseAddr(addrStr)
+		if err != nil {
+			continue
+		}
+
+		a.resolved[dst.host] = addr
+		return netip.AddrPortFrom(addr, dst.port), nil
+	}
+
+	return netip.AddrPort{}, fmt.Errorf("no addresses found for %s", dst.host)
+}
+
+// parseDatagram parses the SOCKS5 UDP request header, returning the
+// destination address and payload.
+func parseDatagram(b []byte) (address, []byte, error) {
+	if len(b) < 4 {
+		return address{}, nil, errors.New("datagram too short")
+	}
+
+	// Fragmentation is optional, and rarely implemented by clients.
+	if b[2] != 0x00 {
+		return address{}, nil, errors.New("fragmented datagrams are not supported")
+	}
+
+	r := bytes.NewReader(b[3:])
+	dst, err := readAddress(r)
+	if err != nil {
+		return address{}, nil, fmt.Errorf("failed to read destination address: %w", err)
+	}
+
+	return dst, b[len(b)-r.Len():], nil
+}
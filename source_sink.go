@@ -56,23 +56,23 @@ var (
 )
 
 type sourceSink struct {
-	logger         *slog.Logger
-	pd             *peerDirectory
-	stack          *stack.Stack
-	ep             *channel.Endpoint
-	notifyHandle   *channel.NotificationHandle
-	incoming       chan *stack.PacketBuffer
-	defaultGateway *types.NoisePublicKey
+	logger       *slog.Logger
+	pd           *peerDirectory
+	acl          *accessControl
+	stack        *stack.Stack
+	ep           *channel.Endpoint
+	notifyHandle *channel.NotificationHandle
+	incoming     chan *stack.PacketBuffer
 }
 
-func newSourceSink(logger *slog.Logger, pd *peerDirectory, s *stack.Stack, defaultGateway *types.NoisePublicKey) (*sourceSink, error) {
+func newSourceSink(logger *slog.Logger, pd *peerDirectory, acl *accessControl, s *stack.Stack, mtu int) (*sourceSink, error) {
 	ss := &sourceSink{
-		logger:         logger,
-		pd:             pd,
-		stack:          s,
-		ep:             channel.New(queueSize, uint32(transport.DefaultMTU), ""),
-		incoming:       make(chan *stack.PacketBuffer),
-		defaultGateway: defaultGateway,
+		logger:   logger,
+		pd:       pd,
+		acl:      acl,
+		stack:    s,
+		ep:       channel.New(queueSize, uint32(mtu), ""),
+		incoming: make(chan *stack.PacketBuffer),
 	}
 
 	ss.notifyHandle = ss.ep.AddNotify(ss)
@@ -122,11 +122,11 @@ func (ss *sourceSink) Read(bufs [][]byte, sizes []int, destinations []types.Nois
 		var ok bool
 		destinations[idx], ok = ss.pd.LookupPeerByAddress(peerAddr)
 		if !ok {
-			if ss.defaultGateway == nil {
-				return fmt.Errorf("unknown destination address %w", syscall.EADDRNOTAVAIL)
-			}
-
-			destinations[idx] = *ss.defaultGateway
+			// The peer may have been removed while the packet was in flight,
+			// drop the packet rather than treating it as a fatal error.
+			ss.logger.Debug("Dropping packet with unknown destination address", "ip", peerAddr.String())
+			sizes[idx] = 0
+			return nil
 		}
 
 		view := pkt.ToView()
@@ -138,6 +138,8 @@ func (ss *sourceSink) Read(bufs [][]byte, sizes []int, destinations []types.Nois
 
 		sizes[idx] = n
 
+		ss.acl.TrackOutbound(bufs[idx][offset : offset+n])
+
 		return nil
 	}
 
@@ -183,50 +185,56 @@ func (ss *sourceSink) Write(bufs [][]byte, sources []types.NoisePublicKey, offse
 		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData(buf[offset:])})
 		switch buf[offset] >> 4 {
 		case 4:
-			// Validate source addresses to prevent spoofing.
-			if ss.defaultGateway == nil || sources[i] != *ss.defaultGateway {
-				hdr := header.IPv4(buf[offset:])
-				if !hdr.IsValid(pkt.Size()) {
-					ss.logger.Warn("Invalid IPv4 header")
-					continue
-				}
-
-				peerAddr := netip.AddrFrom4(hdr.SourceAddress().As4())
-
-				pk, ok := ss.pd.LookupPeerByAddress(peerAddr)
-				if !ok {
-					ss.logger.Warn("Unknown source address", "ip", peerAddr.String())
-					continue
-				}
-
-				if pk != sources[i] {
-					ss.logger.Warn("Invalid source address for peer", "ip", peerAddr.String())
-					continue
-				}
+			// Validate source addresses against the allowed IPs of the peer
+			// to prevent spoofing.
+			hdr := header.IPv4(buf[offset:])
+			if !hdr.IsValid(pkt.Size()) {
+				ss.logger.Warn("Invalid IPv4 header")
+				continue
+			}
+
+			peerAddr := netip.AddrFrom4(hdr.SourceAddress().As4())
+
+			pk, ok := ss.pd.LookupPeerByAddress(peerAddr)
+			if !ok {
+				ss.logger.Warn("Unknown source address", "ip", peerAddr.String())
+				continue
+			}
+
+			if pk != sources[i] {
+				ss.logger.Warn("Invalid source address for peer", "ip", peerAddr.String())
+				continue
+			}
+
+			if !ss.acl.AllowInbound(pk, buf[offset:]) {
+				continue
 			}
 
 			ss.ep.InjectInbound(header.IPv4ProtocolNumber, pkt)
 		case 6:
-			// Validate source addresses to prevent spoofing.
-			if ss.defaultGateway == nil || sources[i] != *ss.defaultGateway {
-				hdr := header.IPv6(pkt.NetworkHeader().View().AsSlice())
-				if !hdr.IsValid(pkt.Size()) {
-					ss.logger.Warn("Invalid IPv6 header")
-					continue
-				}
-
-				peerAddr := netip.AddrFrom16(hdr.SourceAddress().As16())
-
-				pk, ok := ss.pd.LookupPeerByAddress(peerAddr)
-				if !ok {
-					ss.logger.Warn("Unknown source address", "ip", peerAddr.String())
-					continue
-				}
-
-				if pk != sources[i] {
-					ss.logger.Warn("Invalid source address for peer", "ip", peerAddr.String())
-					continue
-				}
+			// Validate source addresses against the allowed IPs of the peer
+			// to prevent spoofing.
+			hdr := header.IPv6(buf[offset:])
+			if !hdr.IsValid(pkt.Size()) {
+				ss.logger.Warn("Invalid IPv6 header")
+				continue
+			}
+
+			peerAddr := netip.AddrFrom16(hdr.SourceAddress().As16())
+
+			pk, ok := ss.pd.LookupPeerByAddress(peerAddr)
+			if !ok {
+				ss.logger.Warn("Unknown source address", "ip", peerAddr.String())
+				continue
+			}
+
+			if pk != sources[i] {
+				ss.logger.Warn("Invalid source address for peer", "ip", peerAddr.String())
+				continue
+			}
+
+			if !ss.acl.AllowInbound(pk, buf[offset:]) {
+				continue
 			}
 
 			ss.ep.InjectInbound(header.IPv6ProtocolNumber, pkt)
@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package noisysockets
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/noisysockets/noisysockets/internal/transport"
+	"github.com/noisysockets/noisysockets/types"
+)
+
+// PeerStats contains runtime statistics for a peer, similar to the output of `wg show`.
+type PeerStats struct {
+	// PublicKey is the public key of the peer.
+	PublicKey types.NoisePublicKey
+	// Name is the optional hostname of the peer.
+	Name string
+	// Endpoint is the current public address/endpoint of the peer (if known).
+	Endpoint netip.AddrPort
+	// TxBytes is the number of bytes sent to the peer.
+	TxBytes uint64
+	// RxBytes is the number of bytes received from the peer.
+	RxBytes uint64
+	// LastHandshake is the time of the last successful handshake (zero if none).
+	LastHandshake time.Time
+	// HandshakeAttempts is the number of handshake attempts since the last
+	// successful handshake.
+	HandshakeAttempts uint32
+	// KeypairCreated is the time the current keypair was created (zero if none).
+	KeypairCreated time.Time
+	// HasValidKeypair indicates whether there is a current keypair that is valid for sending.
+	HasValidKeypair bool
+	// KeepaliveInterval is the current persistent keepalive interval (zero if
+	// disabled), in adaptive mode this may be longer than the configured interval.
+	KeepaliveInterval time.Duration
+	// DeniedConnections is the number of inbound connections from the peer
+	// that were denied by the access control policy.
+	DeniedConnections uint64
+}
+
+// GetPeerStats returns runtime statistics for a peer.
+func (net *NoisySocketsNetwork) GetPeerStats(pk types.NoisePublicKey) (*PeerStats, error) {
+	peer := net.transport.LookupPeer(pk)
+	if peer == nil {
+		return nil, ErrUnknownPeer
+	}
+
+	stats := net.peerStats(pk, peer)
+	return &stats, nil
+}
+
+// GetAllPeerStats returns runtime statistics for all known peers, keyed by public key.
+func (net *NoisySocketsNetwork) GetAllPeerStats() map[types.NoisePublicKey]PeerStats {
+	allStats := make(map[types.NoisePublicKey]PeerStats)
+	for _, pk := range net.transport.Peers() {
+		// The peer may have been removed in the meantime.
+		peer := net.transport.LookupPeer(pk)
+		if peer == nil {
+			continue
+		}
+
+		allStats[pk] = net.peerStats(pk, peer)
+	}
+
+	return allStats
+}
+
+func (net *NoisySocketsNetwork) peerStats(pk types.NoisePublicKey, peer *transport.Peer) PeerStats {
+	stats := PeerStats{
+		PublicKey:         pk,
+		TxBytes:           peer.TxBytes(),
+		RxBytes:           peer.RxBytes(),
+		LastHandshake:     peer.LastHandshake(),
+		HandshakeAttempts: peer.HandshakeAttempts(),
+		KeepaliveInterval: peer.KeepAliveInterval(),
+		DeniedConnections: net.acl.Denied(pk),
+	}
+
+	stats.Name, _ = net.pd.LookupPeerNameByPublicKey(pk)
+
+	if endpoint := peer.GetEndpoint(); endpoint != nil {
+		stats.Endpoint, _ = netip.ParseAddrPort(endpoint.DstToString())
+	}
+
+	stats.KeypairCreated, stats.HasValidKeypair = peer.CurrentKeypairCreated()
+
+	return stats
+}
@@ -113,3 +113,30 @@ func (pk NoisePublicKey) Equals(tar NoisePublicKey) bool {
 func (pk NoisePublicKey) String() string {
 	return base64.StdEncoding.EncodeToString(pk[:])
 }
+
+func NewPresharedKey() (psk NoisePresharedKey, err error) {
+	_, err = rand.Read(psk[:])
+	return
+}
+
+func (psk *NoisePresharedKey) FromString(src string) error {
+	b, err := base64.StdEncoding.DecodeString(src)
+	if err != nil {
+		return err
+	}
+	copy(psk[:], b)
+	return nil
+}
+
+func (psk NoisePresharedKey) IsZero() bool {
+	var zero NoisePresharedKey
+	return psk.Equals(zero)
+}
+
+func (psk NoisePresharedKey) Equals(tar NoisePresharedKey) bool {
+	return subtle.ConstantTimeCompare(psk[:], tar[:]) == 1
+}
+
+func (psk NoisePresharedKey) String() string {
+	return base64.StdEncoding.EncodeToString(psk[:])
+}
@@ -62,3 +62,15 @@ func TestNoisePublicKeyEncoding(t *testing.T) {
 
 	require.Equal(t, pk1, pk2)
 }
+
+func TestNoisePresharedKeyEncoding(t *testing.T) {
+	psk1, err := NewPresharedKey()
+	require.NoError(t, err)
+
+	encoded := psk1.String()
+
+	var psk2 NoisePresharedKey
+	require.NoError(t, psk2.FromString(encoded))
+
+	require.Equal(t, psk1, psk2)
+}
@@ -10,6 +10,7 @@
 package noisysockets
 
 import (
+	"errors"
 	stdnet "net"
 	"net/netip"
 
@@ -19,7 +20,8 @@ import (
 // Addr is a wrapper around net.Addr that includes the source NoisePublicKey.
 type Addr struct {
 	stdnet.Addr
-	pk types.NoisePublicKey
+	pk   types.NoisePublicKey
+	name string
 }
 
 // PublicKey returns the NoisePublicKey of the peer.
@@ -27,6 +29,34 @@ func (a *Addr) PublicKey() types.NoisePublicKey {
 	return a.pk
 }
 
+// Name returns the name of the peer (if known).
+func (a *Addr) Name() string {
+	return a.name
+}
+
+// peerAddr returns the address annotated with the identity of the peer that
+// owns it, or nil if the address isn't a host address of a known peer. An
+// address that is only routed via a peer (eg. a default gateway) could be
+// any host behind it, so it doesn't carry the identity of the peer.
+func peerAddr(pd *peerDirectory, addr stdnet.Addr) *Addr {
+	var ip netip.Addr
+	if addrPort, err := netip.ParseAddrPort(addr.String()); err == nil {
+		ip = addrPort.Addr()
+	} else if ip, err = netip.ParseAddr(addr.String()); err != nil {
+		// Not an IP address, eg. a Unix socket.
+		return nil
+	}
+
+	pk, ok := pd.LookupPeerByHostAddress(ip.Unmap())
+	if !ok {
+		return nil
+	}
+
+	name, _ := pd.LookupPeerNameByPublicKey(pk)
+
+	return &Addr{Addr: addr, pk: pk, name: name}
+}
+
 // Conn is a wrapper around net.Conn that includes the source NoisePublicKey.
 type Conn struct {
 	stdnet.Conn
@@ -39,12 +69,43 @@ func (c *Conn) RemoteAddr() stdnet.Addr {
 		return nil
 	}
 
-	pk, ok := c.pd.LookupPeerByAddress(netip.MustParseAddrPort(remoteAddr.String()).Addr())
-	if !ok {
-		return nil
+	// Routed addresses are returned without an identity.
+	if addr := peerAddr(c.pd, remoteAddr); addr != nil {
+		return addr
 	}
 
-	return &Addr{Addr: c.Conn.RemoteAddr(), pk: pk}
+	return remoteAddr
+}
+
+// CloseRead shuts down the reading side of a TCP connection.
+func (c *Conn) CloseRead() error {
+	if cr, ok := c.Conn.(interface{ CloseRead() error }); ok {
+		return cr.CloseRead()
+	}
+	return errors.ErrUnsupported
+}
+
+// CloseWrite shuts down the writing side of a TCP connection.
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
+
+// udpConn is a connected UDP socket, like *net.UDPConn it also implements
+// net.PacketConn (which some libraries use to detect datagram connections).
+type udpConn struct {
+	Conn
+}
+
+func (c *udpConn) ReadFrom(b []byte) (int, stdnet.Addr, error) {
+	n, err := c.Read(b)
+	return n, c.RemoteAddr(), err
+}
+
+func (c *udpConn) WriteTo(b []byte, addr stdnet.Addr) (int, error) {
+	return c.Write(b)
 }
 
 type listener struct {
@@ -72,12 +133,12 @@ func (pc *packetConn) ReadFrom(b []byte) (int, stdnet.Addr, error) {
 		return n, nil, err
 	}
 
-	pk, ok := pc.pd.LookupPeerByAddress(netip.MustParseAddrPort(addr.String()).Addr())
-	if !ok {
-		return n, nil, err
+	// Routed addresses are returned without an identity.
+	if peerAddr := peerAddr(pc.pd, addr); peerAddr != nil {
+		return n, peerAddr, err
 	}
 
-	return n, &Addr{Addr: addr, pk: pk}, err
+	return n, addr, err
 }
 
 func (pc *packetConn) WriteTo(b []byte, addr stdnet.Addr) (int, error) {